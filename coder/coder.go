@@ -5,6 +5,8 @@ import (
 	"fmt"
 	"io"
 	"log/slog"
+
+	"github.com/easysy/proton/log"
 )
 
 const ContentType = "Content-Type"
@@ -35,7 +37,7 @@ func (e *encoder) Encode(ctx context.Context, w io.Writer, v any) error {
 	enabled := slog.Default().Enabled(ctx, e.lvl)
 
 	if enabled {
-		slog.Log(ctx, e.lvl, "encoder input", "value", v)
+		slog.Log(ctx, e.lvl, "encoder input", "value", log.RedactValue(v))
 	}
 
 	p, err := e.f(v)
@@ -46,9 +48,9 @@ func (e *encoder) Encode(ctx context.Context, w io.Writer, v any) error {
 	if enabled {
 		var attr slog.Attr
 		if e.raw {
-			attr = slog.String("bytes", fmt.Sprintf("% x", p))
+			attr = slog.String("bytes", fmt.Sprintf("% x", log.RedactBody("", p)))
 		} else {
-			attr = slog.String("value", string(p))
+			attr = slog.String("value", string(log.RedactBody("", p)))
 		}
 		slog.Log(ctx, e.lvl, "encoder output", attr, "len", len(p))
 	}
@@ -66,9 +68,10 @@ type Decoder interface {
 }
 
 type decoder struct {
-	f   func(data []byte, v any) error
-	lvl slog.Level
-	raw bool
+	f        func(data []byte, v any) error
+	lvl      slog.Level
+	raw      bool
+	validate func(v any) error
 }
 
 // NewDecoder returns a new Decoder that reads from r.
@@ -93,9 +96,9 @@ func (d *decoder) Decode(ctx context.Context, r io.Reader, v any) error {
 	if enabled {
 		var attr slog.Attr
 		if d.raw {
-			attr = slog.String("bytes", fmt.Sprintf("% x", p))
+			attr = slog.String("bytes", fmt.Sprintf("% x", log.RedactBody("", p)))
 		} else {
-			attr = slog.String("value", string(p))
+			attr = slog.String("value", string(log.RedactBody("", p)))
 		}
 		slog.Log(ctx, d.lvl, "decoder input", attr, "len", len(p))
 	}
@@ -105,7 +108,11 @@ func (d *decoder) Decode(ctx context.Context, r io.Reader, v any) error {
 	}
 
 	if enabled {
-		slog.Log(ctx, d.lvl, "decoder output", "value", v)
+		slog.Log(ctx, d.lvl, "decoder output", "value", log.RedactValue(v))
+	}
+
+	if d.validate != nil && ctx.Value(skipValidationCtxKey{}) == nil {
+		return d.validate(v)
 	}
 
 	return nil
@@ -9,6 +9,7 @@ import (
 	"testing"
 
 	"github.com/easysy/proton/coder"
+	"github.com/easysy/proton/validate"
 )
 
 func equal(t *testing.T, exp, got any) {
@@ -87,3 +88,23 @@ func TestDecoder_Decode(t *testing.T) {
 		})
 	}
 }
+
+func TestDecoder_Validator(t *testing.T) {
+	decoder := coder.NewDecoder(json.Unmarshal, coder.WithValidator(validate.Struct))
+
+	v := &struct {
+		Field string `json:"field" validate:"required"`
+	}{}
+
+	err := decoder.Decode(context.Background(), bytes.NewBufferString(`{"field":""}`), v)
+
+	var errs validate.Errors
+	equal(t, true, errors.As(err, &errs))
+	equal(t, "field: is required", err.Error())
+
+	err = decoder.Decode(context.Background(), bytes.NewBufferString(`{"field":"example"}`), v)
+	equal(t, nil, err)
+
+	err = decoder.Decode(coder.WithoutValidation(context.Background()), bytes.NewBufferString(`{"field":""}`), v)
+	equal(t, nil, err)
+}
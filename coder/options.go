@@ -1,6 +1,9 @@
 package coder
 
-import "log/slog"
+import (
+	"context"
+	"log/slog"
+)
 
 type Options interface {
 	applyEnc(*encoder)
@@ -34,3 +37,25 @@ func (l level) applyDec(d *decoder) {
 func WithLogLevel(lvl slog.Level) Options {
 	return level(lvl)
 }
+
+type validator func(v any) error
+
+func (f validator) applyEnc(*encoder) {}
+
+func (f validator) applyDec(d *decoder) {
+	d.validate = f
+}
+
+// WithValidator sets a function, e.g. validate.Struct, called by the Decoder with every decoded value.
+// Its error is returned by Decode as is. It has no effect on the Encoder.
+func WithValidator(f func(v any) error) Options {
+	return validator(f)
+}
+
+type skipValidationCtxKey struct{}
+
+// WithoutValidation returns a context in which Decode does not call the validator set by WithValidator,
+// e.g. because the caller validates the value itself once it has been completed from other sources.
+func WithoutValidation(ctx context.Context) context.Context {
+	return context.WithValue(ctx, skipValidationCtxKey{}, true)
+}
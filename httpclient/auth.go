@@ -0,0 +1,337 @@
+package httpclient
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// BearerAuth sets the "Authorization: Bearer <token>" header of requests that have no Authorization header.
+func BearerAuth(token string) func(http.RoundTripper) http.RoundTripper {
+	return headerAuth("Authorization", "Bearer "+token)
+}
+
+// BasicAuth sets the basic authentication header of requests that have no Authorization header.
+func BasicAuth(username, password string) func(http.RoundTripper) http.RoundTripper {
+	r := &http.Request{Header: make(http.Header)}
+	r.SetBasicAuth(username, password)
+	return headerAuth("Authorization", r.Header.Get("Authorization"))
+}
+
+// APIKeyAuth sets the header, e.g. "X-API-Key", to the key in requests that do not have it.
+func APIKeyAuth(header, key string) func(http.RoundTripper) http.RoundTripper {
+	return headerAuth(header, key)
+}
+
+func headerAuth(header, value string) func(http.RoundTripper) http.RoundTripper {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripper(func(r *http.Request) (*http.Response, error) {
+			if r.Header.Get(header) != "" {
+				return next.RoundTrip(r)
+			}
+			r = r.Clone(r.Context())
+			r.Header.Set(header, value)
+			return next.RoundTrip(r)
+		})
+	}
+}
+
+// Token is an OAuth2 access token.
+type Token struct {
+	AccessToken string
+	TokenType   string
+
+	// Expiry is the time the token expires, or zero if it does not.
+	Expiry time.Time
+
+	// refreshAt is the time the token is replaced with a new one.
+	refreshAt time.Time
+}
+
+// TokenError is an error response of an OAuth2 token endpoint (RFC 6749, 5.2).
+type TokenError struct {
+	StatusCode  int
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *TokenError) Error() string {
+	s := fmt.Sprintf("httpclient: token endpoint: %d %s", e.StatusCode, http.StatusText(e.StatusCode))
+	if e.Code != "" {
+		s += ": " + e.Code
+	}
+	if e.Description != "" {
+		s += ": " + e.Description
+	}
+	return s
+}
+
+// ClientCredentials is an OAuth2 token source using the client credentials grant (RFC 6749, 4.4).
+// It caches the token and fetches a new one in the background shortly before it expires, serving the
+// current token meanwhile and as long as it is valid if the fetch fails. Concurrent callers share a single
+// token request, and failed requests are retried with an exponential backoff of up to a minute.
+// Its fields must not be changed after the first use.
+type ClientCredentials struct {
+	// TokenURL is the URL of the token endpoint of the authorization server.
+	TokenURL string
+
+	ClientID     string
+	ClientSecret string
+
+	// Scopes are the requested scopes, if any.
+	Scopes []string
+
+	// Params are additional parameters of the token request, e.g. "audience".
+	Params url.Values
+
+	// AuthInBody sends the client credentials in the request body instead of the basic authentication header.
+	AuthInBody bool
+
+	// Client sends the token requests. Defaults to http.DefaultClient.
+	Client *http.Client
+
+	// RefreshBefore is how long before its expiry a token is replaced. Defaults to 30 seconds,
+	// and is at most half of the lifetime of the token.
+	RefreshBefore time.Duration
+
+	mu       sync.Mutex
+	token    *Token
+	fetch    *tokenFetch
+	failures int
+	retryAt  time.Time
+	err      error
+}
+
+type tokenFetch struct {
+	done  chan struct{}
+	token *Token
+	err   error
+}
+
+const (
+	// tokenFetchTimeout limits a token request not bounded by the timeout of the Client.
+	tokenFetchTimeout = 30 * time.Second
+
+	// tokenRetryMin and tokenRetryMax bound the delay before a failed token request is retried.
+	tokenRetryMin = time.Second
+	tokenRetryMax = time.Minute
+)
+
+// Token returns the cached token, or fetches a new one if there is none or it is about to expire.
+// While a token that is about to expire is replaced, it is still returned.
+// After a failed request, the error is returned until the backoff delay has passed.
+func (c *ClientCredentials) Token(ctx context.Context) (*Token, error) {
+	c.mu.Lock()
+
+	now := time.Now()
+
+	if token := c.token; token != nil && (token.Expiry.IsZero() || now.Before(token.Expiry)) {
+		// A token about to expire is replaced in the background, unless a request has failed recently.
+		if !token.refreshAt.IsZero() && !now.Before(token.refreshAt) && !now.Before(c.retryAt) {
+			c.start(ctx)
+		}
+		c.mu.Unlock()
+		return token, nil
+	}
+
+	if now.Before(c.retryAt) {
+		err := c.err
+		c.mu.Unlock()
+		return nil, err
+	}
+
+	f := c.start(ctx)
+	c.mu.Unlock()
+
+	select {
+	case <-f.done:
+		return f.token, f.err
+	case <-ctx.Done():
+		return nil, ctx.Err()
+	}
+}
+
+// start starts a token request unless one is in progress, and returns it. It must be called with c.mu held.
+func (c *ClientCredentials) start(ctx context.Context) *tokenFetch {
+	if c.fetch != nil {
+		return c.fetch
+	}
+
+	f := &tokenFetch{done: make(chan struct{})}
+	c.fetch = f
+
+	// The token is fetched for all callers, so no single caller may cancel it.
+	go func() {
+		fetchCtx, cancel := context.WithTimeout(context.WithoutCancel(ctx), tokenFetchTimeout)
+		defer cancel()
+
+		f.token, f.err = c.request(fetchCtx)
+
+		c.mu.Lock()
+		if f.err == nil {
+			c.token, c.failures, c.retryAt, c.err = f.token, 0, time.Time{}, nil
+		} else {
+			c.failures++
+			c.retryAt = time.Now().Add(min(tokenRetryMin<<min(c.failures-1, 6), tokenRetryMax))
+			c.err = f.err
+		}
+		c.fetch = nil
+		c.mu.Unlock()
+
+		close(f.done)
+	}()
+
+	return f
+}
+
+// invalidate drops the token if it is still the cached one, so that the next call of Token fetches a new one.
+func (c *ClientCredentials) invalidate(token *Token) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.token == token {
+		c.token = nil
+	}
+}
+
+// request fetches a new token from the token endpoint.
+func (c *ClientCredentials) request(ctx context.Context) (*Token, error) {
+	form := url.Values{"grant_type": {"client_credentials"}}
+	for key, values := range c.Params {
+		form[key] = values
+	}
+	if len(c.Scopes) > 0 {
+		form.Set("scope", strings.Join(c.Scopes, " "))
+	}
+	if c.AuthInBody {
+		form.Set("client_id", c.ClientID)
+		form.Set("client_secret", c.ClientSecret)
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, c.TokenURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Accept", "application/json")
+	if !c.AuthInBody {
+		r.SetBasicAuth(url.QueryEscape(c.ClientID), url.QueryEscape(c.ClientSecret))
+	}
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	response, err := client.Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer func() { _ = response.Body.Close() }()
+
+	body, err := io.ReadAll(io.LimitReader(response.Body, 1<<20))
+	if err != nil {
+		return nil, err
+	}
+
+	if response.StatusCode < http.StatusOK || response.StatusCode >= http.StatusMultipleChoices {
+		e := &TokenError{StatusCode: response.StatusCode}
+		_ = json.Unmarshal(body, e)
+		return nil, e
+	}
+
+	var payload struct {
+		AccessToken string `json:"access_token"`
+		TokenType   string `json:"token_type"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err = json.Unmarshal(body, &payload); err != nil {
+		return nil, fmt.Errorf("httpclient: token endpoint: %w", err)
+	}
+	if payload.AccessToken == "" {
+		return nil, &TokenError{StatusCode: response.StatusCode, Description: "no access token in the response"}
+	}
+
+	token := &Token{AccessToken: payload.AccessToken, TokenType: payload.TokenType}
+
+	if payload.ExpiresIn > 0 {
+		lifetime := time.Duration(payload.ExpiresIn) * time.Second
+
+		refreshBefore := c.RefreshBefore
+		if refreshBefore <= 0 {
+			refreshBefore = 30 * time.Second
+		}
+
+		now := time.Now()
+		token.Expiry = now.Add(lifetime)
+		token.refreshAt = now.Add(lifetime - min(refreshBefore, lifetime/2))
+	}
+
+	return token, nil
+}
+
+// OAuth2 authenticates requests with the access token of the client credentials, unless they have
+// an Authorization header. A request answered with 401 Unauthorized is retried once with a new token,
+// if its body can be sent again (see http.Request.GetBody).
+func OAuth2(credentials *ClientCredentials) func(http.RoundTripper) http.RoundTripper {
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripper(func(r *http.Request) (*http.Response, error) {
+			if r.Header.Get("Authorization") != "" {
+				return next.RoundTrip(r)
+			}
+
+			token, err := credentials.Token(r.Context())
+			if err != nil {
+				return nil, err
+			}
+
+			response, err := next.RoundTrip(authorize(r, token))
+			if err != nil || response.StatusCode != http.StatusUnauthorized {
+				return response, err
+			}
+
+			replayable := r.Body == nil || r.Body == http.NoBody || r.GetBody != nil
+			if !replayable {
+				return response, nil
+			}
+
+			credentials.invalidate(token)
+
+			fresh, err := credentials.Token(r.Context())
+			if err != nil || fresh == token {
+				// Without a new token the retry would fail in the same way.
+				return response, nil
+			}
+
+			retry := authorize(r, fresh)
+			if r.Body != nil && r.Body != http.NoBody {
+				if retry.Body, err = r.GetBody(); err != nil {
+					return response, nil
+				}
+			}
+
+			_, _ = io.Copy(io.Discard, io.LimitReader(response.Body, 4<<10))
+			_ = response.Body.Close()
+
+			return next.RoundTrip(retry)
+		})
+	}
+}
+
+// authorize returns a copy of the request with the Authorization header of the token.
+func authorize(r *http.Request, token *Token) *http.Request {
+	tokenType := token.TokenType
+	if tokenType == "" || strings.EqualFold(tokenType, "bearer") {
+		tokenType = "Bearer"
+	}
+
+	r = r.Clone(r.Context())
+	r.Header.Set("Authorization", tokenType+" "+token.AccessToken)
+	return r
+}
@@ -0,0 +1,432 @@
+package httpclient
+
+import (
+	"cmp"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// BalanceStrategy selects the endpoint of a request sent through the Balance middleware.
+type BalanceStrategy int
+
+const (
+	// BalanceRoundRobin sends requests to the endpoints in turn.
+	BalanceRoundRobin BalanceStrategy = iota
+	// BalanceLeastInFlight sends a request to the endpoint with the fewest requests in progress;
+	// a request is in progress until its response body is closed.
+	BalanceLeastInFlight
+	// BalanceConsistentHash sends requests with the same hash key to the same endpoint,
+	// and moves few keys when endpoints are added or removed.
+	BalanceConsistentHash
+)
+
+// BalanceOptions represents configuration for the Balance middleware.
+type BalanceOptions struct {
+	// Service is the logical host name of the service, e.g. "users" for requests to "http://users/v1/users".
+	Service string
+
+	// Endpoints are the base URLs of the instances of the service, e.g. "http://10.0.0.1:8080".
+	// A path of an endpoint is prepended to the path of the request.
+	Endpoints []string
+
+	// Resolver returns the current endpoints, e.g. from DNS or a service registry. It is used instead
+	// of Endpoints if set, and called again every ResolveInterval. If it fails or returns no endpoints,
+	// the previous endpoints are kept and the call is retried after a delay growing from one second
+	// up to ResolveInterval. Concurrent requests share one call; while there are no endpoints they wait
+	// for it, and fail with ErrNoEndpoints until the retry delay has passed.
+	Resolver func(ctx context.Context) ([]string, error)
+
+	// ResolveInterval is the interval of calls to Resolver. Defaults to 30 seconds.
+	ResolveInterval time.Duration
+
+	// Strategy selects the endpoint of a request. Defaults to BalanceRoundRobin.
+	Strategy BalanceStrategy
+
+	// HashKey returns the key of the request for BalanceConsistentHash. Defaults to the URL path.
+	HashKey func(r *http.Request) string
+
+	// MaxFailures is the number of consecutive failures (errors and 5xx responses) after which
+	// an endpoint is ejected. Defaults to 5.
+	MaxFailures int
+
+	// EjectionTime is how long an ejected endpoint receives no requests. Defaults to 30 seconds.
+	EjectionTime time.Duration
+}
+
+// Balance distributes the requests to the Service host among its endpoints by rewriting their URL;
+// requests to other hosts are sent as is. The scheme and the host of the request are replaced with
+// those of the chosen endpoint, and the Host header is set to the endpoint host.
+//
+// Endpoints are ejected passively: an endpoint failing MaxFailures times in a row receives no requests
+// for EjectionTime. If all endpoints are ejected, requests are sent to all of them, so that the service
+// recovers as soon as it can. It panics if Service is empty or if there are neither Endpoints nor Resolver,
+// or if an endpoint is not an absolute URL.
+func Balance(opts *BalanceOptions) func(http.RoundTripper) http.RoundTripper {
+	b := newBalancer(opts)
+
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripper(func(r *http.Request) (*http.Response, error) {
+			if !strings.EqualFold(r.URL.Hostname(), b.service) {
+				return next.RoundTrip(r)
+			}
+
+			e, err := b.pick(r)
+			if err != nil {
+				return nil, err
+			}
+
+			e.inFlight.Add(1)
+			release := sync.OnceFunc(func() { e.inFlight.Add(-1) })
+
+			response, err := next.RoundTrip(e.rewrite(r))
+
+			switch {
+			case err != nil:
+				release()
+				if r.Context().Err() == nil {
+					b.failed(r.Context(), e)
+				}
+				return nil, err
+			case response.StatusCode >= http.StatusInternalServerError:
+				b.failed(r.Context(), e)
+			default:
+				e.succeeded()
+			}
+
+			response.Body = wrapBody(response.Body, &releaseBody{ReadCloser: response.Body, release: release})
+
+			return response, nil
+		})
+	}
+}
+
+// ErrNoEndpoints is returned by the Balance middleware when the service has no endpoints.
+var ErrNoEndpoints = errors.New("httpclient: no endpoints")
+
+type endpoint struct {
+	raw string
+	url *url.URL
+
+	inFlight atomic.Int64
+
+	mu           sync.Mutex
+	failures     int
+	ejectedUntil time.Time
+}
+
+func parseEndpoint(raw string) (*endpoint, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return nil, errors.New("endpoint " + strconv.Quote(raw) + " is not an absolute URL")
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/")
+	u.RawPath = ""
+	return &endpoint{raw: raw, url: u}, nil
+}
+
+func (e *endpoint) ejected(now time.Time) bool {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	return now.Before(e.ejectedUntil)
+}
+
+func (e *endpoint) succeeded() {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.failures = 0
+}
+
+// rewrite returns the request sent to the endpoint.
+func (e *endpoint) rewrite(r *http.Request) *http.Request {
+	r = r.Clone(r.Context())
+
+	u := *r.URL
+	u.Scheme = e.url.Scheme
+	u.Host = e.url.Host
+	if e.url.Path != "" {
+		u.Path = e.url.Path + u.Path
+		if u.RawPath != "" {
+			u.RawPath = e.url.EscapedPath() + u.RawPath
+		}
+	}
+
+	r.URL = &u
+	r.Host = ""
+
+	return r
+}
+
+type balancer struct {
+	service     string
+	strategy    BalanceStrategy
+	hashKey     func(r *http.Request) string
+	maxFailures int
+	ejection    time.Duration
+
+	resolver func(ctx context.Context) ([]string, error)
+	interval time.Duration
+
+	resolveMu sync.Mutex
+	flight    chan struct{} // closed when the current call of the resolver is done, nil if there is none
+	failures  int
+	retryAt   time.Time
+
+	mu        sync.RWMutex
+	endpoints []*endpoint
+	ring      []ringPoint
+	resolved  time.Time
+
+	next atomic.Uint64
+}
+
+// ringPoint is a point of an endpoint on the consistent hash ring.
+type ringPoint struct {
+	hash     uint64
+	endpoint *endpoint
+}
+
+// resolveRetryMin is the delay before a failed call of the resolver is retried, doubled on every failure.
+const resolveRetryMin = time.Second
+
+// ringReplicas is the number of points of every endpoint on the ring, which evens out the distribution.
+const ringReplicas = 100
+
+func newBalancer(opts *BalanceOptions) *balancer {
+	if opts == nil || opts.Service == "" {
+		panic("httpclient: balance: no service")
+	}
+	if len(opts.Endpoints) == 0 && opts.Resolver == nil {
+		panic("httpclient: balance: no endpoints or resolver")
+	}
+
+	b := &balancer{
+		service:     opts.Service,
+		strategy:    opts.Strategy,
+		hashKey:     opts.HashKey,
+		maxFailures: opts.MaxFailures,
+		ejection:    opts.EjectionTime,
+		resolver:    opts.Resolver,
+		interval:    opts.ResolveInterval,
+	}
+
+	if b.hashKey == nil {
+		b.hashKey = func(r *http.Request) string { return r.URL.Path }
+	}
+	if b.maxFailures <= 0 {
+		b.maxFailures = 5
+	}
+	if b.ejection <= 0 {
+		b.ejection = 30 * time.Second
+	}
+	if b.interval <= 0 {
+		b.interval = 30 * time.Second
+	}
+
+	if b.resolver == nil {
+		if err := b.update(opts.Endpoints); err != nil {
+			panic("httpclient: balance: " + err.Error())
+		}
+	}
+
+	return b
+}
+
+// update replaces the endpoints, keeping the state of the ones that remain.
+func (b *balancer) update(raws []string) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	current := make(map[string]*endpoint, len(b.endpoints))
+	for _, e := range b.endpoints {
+		current[e.raw] = e
+	}
+
+	endpoints := make([]*endpoint, 0, len(raws))
+	for _, raw := range raws {
+		e, ok := current[raw]
+		if !ok {
+			var err error
+			if e, err = parseEndpoint(raw); err != nil {
+				return err
+			}
+		}
+		endpoints = append(endpoints, e)
+	}
+
+	ring := make([]ringPoint, 0, len(endpoints)*ringReplicas)
+	for _, e := range endpoints {
+		for i := range ringReplicas {
+			ring = append(ring, ringPoint{hash: hashString(e.raw + "#" + strconv.Itoa(i)), endpoint: e})
+		}
+	}
+	slices.SortFunc(ring, func(a, b ringPoint) int { return cmp.Compare(a.hash, b.hash) })
+
+	b.endpoints, b.ring, b.resolved = endpoints, ring, time.Now()
+
+	return nil
+}
+
+// resolve refreshes the endpoints with the resolver: at once if there are none yet, in the background otherwise.
+// Concurrent callers share one call of the resolver, and a failed call is not retried before retryAt.
+func (b *balancer) resolve(ctx context.Context) {
+	if b.resolver == nil {
+		return
+	}
+
+	b.mu.RLock()
+	empty, stale := len(b.endpoints) == 0, time.Since(b.resolved) >= b.interval
+	b.mu.RUnlock()
+
+	if !empty && !stale {
+		return
+	}
+
+	b.resolveMu.Lock()
+	flight := b.flight
+	if flight == nil {
+		if time.Now().Before(b.retryAt) {
+			b.resolveMu.Unlock()
+			return
+		}
+		flight = make(chan struct{})
+		b.flight = flight
+		go b.refresh(context.WithoutCancel(ctx), flight)
+	}
+	b.resolveMu.Unlock()
+
+	if empty {
+		select {
+		case <-flight:
+		case <-ctx.Done():
+		}
+	}
+}
+
+// refresh calls the resolver and updates the endpoints, then closes flight.
+func (b *balancer) refresh(ctx context.Context, flight chan struct{}) {
+	raws, err := b.resolver(ctx)
+	if err == nil && len(raws) == 0 {
+		err = ErrNoEndpoints
+	}
+	if err == nil {
+		err = b.update(raws)
+	}
+	if err != nil {
+		slog.ErrorContext(ctx, "resolve endpoints", "service", b.service, "error", err)
+	}
+
+	b.resolveMu.Lock()
+	if err != nil {
+		b.failures++
+		b.retryAt = time.Now().Add(min(resolveRetryMin<<min(b.failures-1, 6), b.interval))
+	} else {
+		b.failures = 0
+	}
+	b.flight = nil
+	b.resolveMu.Unlock()
+
+	close(flight)
+}
+
+func (b *balancer) pick(r *http.Request) (*endpoint, error) {
+	b.resolve(r.Context())
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	if len(b.endpoints) == 0 {
+		return nil, ErrNoEndpoints
+	}
+
+	now := time.Now()
+
+	healthy := make([]*endpoint, 0, len(b.endpoints))
+	for _, e := range b.endpoints {
+		if !e.ejected(now) {
+			healthy = append(healthy, e)
+		}
+	}
+
+	// If all endpoints are ejected, the ejection is ignored.
+	all := len(healthy) == 0
+	if all {
+		healthy = b.endpoints
+	}
+
+	switch b.strategy {
+	case BalanceLeastInFlight:
+		start := int(b.next.Add(1) % uint64(len(healthy)))
+		best := healthy[start]
+		for i := 1; i < len(healthy); i++ {
+			if e := healthy[(start+i)%len(healthy)]; e.inFlight.Load() < best.inFlight.Load() {
+				best = e
+			}
+		}
+		return best, nil
+
+	case BalanceConsistentHash:
+		hash := hashString(b.hashKey(r))
+		i, _ := slices.BinarySearchFunc(b.ring, hash, func(p ringPoint, h uint64) int { return cmp.Compare(p.hash, h) })
+		for n := range b.ring {
+			p := b.ring[(i+n)%len(b.ring)]
+			if all || !p.endpoint.ejected(now) {
+				return p.endpoint, nil
+			}
+		}
+		return b.ring[i%len(b.ring)].endpoint, nil
+
+	default:
+		return healthy[int(b.next.Add(1)%uint64(len(healthy)))], nil
+	}
+}
+
+// failed counts a failure of the endpoint and ejects it after MaxFailures consecutive ones.
+func (b *balancer) failed(ctx context.Context, e *endpoint) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if e.failures++; e.failures < b.maxFailures {
+		return
+	}
+
+	e.failures = 0
+	e.ejectedUntil = time.Now().Add(b.ejection)
+
+	slog.WarnContext(ctx, "endpoint ejected", "service", b.service, "endpoint", e.raw, "duration", b.ejection.String())
+}
+
+// hashString returns a well-mixed hash of the string: keys that differ in a single character,
+// such as the points of an endpoint, still land far apart on the ring.
+func hashString(s string) uint64 {
+	sum := sha256.Sum256([]byte(s))
+	return binary.BigEndian.Uint64(sum[:8])
+}
+
+// releaseBody calls release when it is closed.
+type releaseBody struct {
+	io.ReadCloser
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.release()
+	return err
+}
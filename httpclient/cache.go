@@ -0,0 +1,604 @@
+package httpclient
+
+import (
+	"bytes"
+	"encoding/gob"
+	"hash/fnv"
+	"io"
+	"maps"
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// CacheStatusHeader is the response header reporting how the Cache middleware handled the request (RFC 9211).
+const CacheStatusHeader = "Cache-Status"
+
+// The values returned by CacheStatus.
+const (
+	CacheHit         = "hit"
+	CacheRevalidated = "revalidated"
+	CacheMiss        = "miss"
+)
+
+// cacheName identifies the Cache middleware in the Cache-Status header.
+const cacheName = "proton"
+
+// maxCacheBodySize is the size of the largest body stored by the Cache middleware.
+const maxCacheBodySize = 10 << 20
+
+// maxCacheDrainSize is how much of a body closed before its end is read to store the response.
+const maxCacheDrainSize = 64 << 10
+
+// maxCacheVariants is the number of responses varying on request headers stored for a URL.
+const maxCacheVariants = 8
+
+// Cache is a private HTTP cache (RFC 9111) keeping the responses to GET requests in the store.
+//
+// A stored response is served while it is fresh, according to its Cache-Control max-age directive,
+// its Expires header or, in their absence, a heuristic based on Last-Modified. A stale response,
+// or one with Cache-Control no-cache, is revalidated with If-None-Match and If-Modified-Since,
+// and served again when the server answers 304 Not Modified. Responses are selected by the request
+// headers listed in their Vary header, and up to 8 variants are stored for a URL; responses with
+// Cache-Control no-store or "Vary: *" are not stored. The request directives no-store, no-cache,
+// max-age, min-fresh and max-stale are honoured, and a successful unsafe request (e.g. POST) invalidates
+// the responses stored for its URL and for the URLs of its Location and Content-Location headers.
+// Requests with a Range or a conditional header of their own bypass the cache, and bodies over 10 MiB are not stored.
+//
+// The variants of a URL are listed under its key and stored under keys of their own, so that a hit decodes
+// one response. Updates of the variants of a URL are serialized within the middleware.
+//
+// A response is stored once its body is read to the end. If the body is closed earlier, the rest is read
+// to store it as long as it is no larger than 64 KiB; otherwise the response is not stored.
+//
+// Every response passing through the cache has a Cache-Status header (see CacheStatus).
+// Place Cache before Timer and DumpHttp in RoundTripperSequencer to have them log the cache status.
+func Cache(store CacheStore) func(http.RoundTripper) http.RoundTripper {
+	c := &responseCache{store: store}
+
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripper(func(r *http.Request) (*http.Response, error) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				response, err := next.RoundTrip(r)
+				if err == nil && r.Method != http.MethodOptions && r.Method != http.MethodTrace &&
+					response.StatusCode < http.StatusBadRequest {
+					c.invalidate(r, response)
+				}
+				return response, err
+			}
+
+			directives := parseCacheControl(r.Header)
+			if _, ok := directives["no-store"]; ok || bypassCache(r) {
+				return next.RoundTrip(r)
+			}
+
+			key := cacheKey(r.URL)
+
+			entry := c.load(key, r)
+
+			forward := "uri-miss"
+			if entry != nil {
+				if entry.fresh(directives, r.Header, time.Now()) {
+					return entry.response(r, cacheName+"; hit"), nil
+				}
+				forward = "stale"
+			}
+
+			outgoing := r
+			if entry != nil {
+				outgoing = entry.conditional(r)
+			}
+
+			requestTime := time.Now()
+			response, err := next.RoundTrip(outgoing)
+			if err != nil {
+				return nil, err
+			}
+			responseTime := time.Now()
+
+			if entry != nil && response.StatusCode == http.StatusNotModified && outgoing != r {
+				_, _ = io.Copy(io.Discard, response.Body)
+				_ = response.Body.Close()
+
+				entry.update(response.Header, requestTime, responseTime)
+				c.save(key, entry)
+
+				return entry.response(r, cacheName+"; fwd=stale; fwd-status=304"), nil
+			}
+
+			if r.Method == http.MethodGet && storable(response) {
+				entry = newCacheEntry(r, response, requestTime, responseTime)
+				response.Body = &cachingBody{ReadCloser: response.Body, done: func(body []byte) {
+					entry.Body = body
+					c.save(key, entry)
+				}}
+			}
+
+			response.Header.Add(CacheStatusHeader, cacheName+"; fwd="+forward+"; fwd-status="+strconv.Itoa(response.StatusCode))
+
+			return response, nil
+		})
+	}
+}
+
+// CacheStatus returns how the Cache middleware handled the request of the response: CacheHit if the response
+// was served from the cache, CacheRevalidated if it was served after a 304 Not Modified, CacheMiss if it
+// came from the server, or "" if the response did not pass through the cache.
+func CacheStatus(response *http.Response) string {
+	if response == nil {
+		return ""
+	}
+
+	values := response.Header.Values(CacheStatusHeader)
+	for i := len(values) - 1; i >= 0; i-- {
+		members := strings.Split(values[i], ",")
+		for j := len(members) - 1; j >= 0; j-- {
+			name, params, _ := strings.Cut(members[j], ";")
+			if strings.TrimSpace(name) != cacheName {
+				continue
+			}
+			switch {
+			case strings.Contains(params, "hit"):
+				return CacheHit
+			case strings.Contains(params, "fwd-status=304"):
+				return CacheRevalidated
+			default:
+				return CacheMiss
+			}
+		}
+	}
+	return ""
+}
+
+// cacheKey returns the key of the responses to the URL.
+func cacheKey(u *url.URL) string {
+	key := *u
+	key.Fragment, key.RawFragment = "", ""
+	return key.String()
+}
+
+// invalidate removes the responses stored for the URL of the unsafe request and for the URLs of the Location
+// and Content-Location headers of its response, if they have the same origin (RFC 9111, 4.4).
+func (c *responseCache) invalidate(r *http.Request, response *http.Response) {
+	c.delete(cacheKey(r.URL))
+
+	for _, name := range []string{"Location", "Content-Location"} {
+		value := response.Header.Get(name)
+		if value == "" {
+			continue
+		}
+		ref, err := url.Parse(value)
+		if err != nil {
+			continue
+		}
+		u := r.URL.ResolveReference(ref)
+		if strings.EqualFold(u.Scheme, r.URL.Scheme) && strings.EqualFold(u.Host, r.URL.Host) {
+			c.delete(cacheKey(u))
+		}
+	}
+}
+
+// bypassCache reports whether the request must be sent as is: a partial or a conditional request of the caller.
+func bypassCache(r *http.Request) bool {
+	for _, name := range []string{"Range", "If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since"} {
+		if r.Header.Get(name) != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// parseCacheControl returns the Cache-Control directives of the header by lower-case name, with unquoted values.
+func parseCacheControl(h http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range h.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				directives[name] = strings.Trim(strings.TrimSpace(arg), `"`)
+			}
+		}
+	}
+	return directives
+}
+
+// seconds returns the value of a delta-seconds directive, and false if it is absent or invalid.
+func seconds(directives map[string]string, name string) (time.Duration, bool) {
+	value, ok := directives[name]
+	if !ok {
+		return 0, false
+	}
+	n, err := strconv.ParseInt(value, 10, 64)
+	if err != nil || n < 0 {
+		return 0, false
+	}
+	return time.Duration(n) * time.Second, true
+}
+
+// heuristicallyCacheable lists the status codes cacheable by default (RFC 9110, 15.1).
+var heuristicallyCacheable = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+}
+
+// storable reports whether the response may be stored and is worth it: it is fresh for some time
+// or can be revalidated.
+func storable(response *http.Response) bool {
+	directives := parseCacheControl(response.Header)
+	if _, ok := directives["no-store"]; ok {
+		return false
+	}
+
+	for _, value := range response.Header.Values("Vary") {
+		if strings.TrimSpace(value) == "*" {
+			return false
+		}
+	}
+
+	if response.ContentLength > maxCacheBodySize {
+		return false
+	}
+
+	_, explicit := seconds(directives, "max-age")
+	explicit = explicit || response.Header.Get("Expires") != ""
+	if !explicit && !heuristicallyCacheable[response.StatusCode] {
+		return false
+	}
+
+	return explicit || response.Header.Get("ETag") != "" || response.Header.Get("Last-Modified") != ""
+}
+
+// cacheEntry is a stored response.
+type cacheEntry struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+
+	// Vary holds the values of the request headers listed in the Vary header of the response.
+	Vary map[string]string
+
+	RequestTime  time.Time
+	ResponseTime time.Time
+}
+
+func newCacheEntry(r *http.Request, response *http.Response, requestTime, responseTime time.Time) *cacheEntry {
+	e := &cacheEntry{
+		StatusCode:   response.StatusCode,
+		Header:       response.Header.Clone(),
+		Vary:         make(map[string]string),
+		RequestTime:  requestTime,
+		ResponseTime: responseTime,
+	}
+
+	for _, name := range varyNames(response.Header) {
+		e.Vary[name] = strings.Join(r.Header.Values(name), ",")
+	}
+
+	return e
+}
+
+func varyNames(h http.Header) []string {
+	var names []string
+	for _, value := range h.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				names = append(names, http.CanonicalHeaderKey(name))
+			}
+		}
+	}
+	return names
+}
+
+// responseCache stores the responses of the Cache middleware. The key of a URL holds the list of its variants,
+// the most recent first, and every variant is stored under a key of its own (see variantKey).
+type responseCache struct {
+	store CacheStore
+
+	// locks serialize the updates of the variants of a URL, selected by the hash of its key.
+	locks [64]sync.Mutex
+}
+
+// cacheVariant is an item of the list of the variants stored for a URL.
+type cacheVariant struct {
+	// Vary holds the values of the request headers listed in the Vary header of the response.
+	Vary map[string]string
+}
+
+func (c *responseCache) lock(key string) *sync.Mutex {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(key))
+	return &c.locks[h.Sum32()%uint32(len(c.locks))]
+}
+
+// variantKey returns the key of the response stored for the URL key and the values of the Vary headers.
+func variantKey(key string, vary map[string]string) string {
+	names := make([]string, 0, len(vary))
+	for name := range vary {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+
+	var b strings.Builder
+	b.WriteString(key + "\n")
+	for _, name := range names {
+		b.WriteString(name + ": " + vary[name] + "\n")
+	}
+	return b.String()
+}
+
+// variants returns the list of the variants stored for the URL key.
+func (c *responseCache) variants(key string) []cacheVariant {
+	p, ok := c.store.Get(key)
+	if !ok {
+		return nil
+	}
+
+	var variants []cacheVariant
+	if err := gob.NewDecoder(bytes.NewReader(p)).Decode(&variants); err != nil {
+		c.store.Delete(key)
+		return nil
+	}
+	return variants
+}
+
+// load returns the most recent variant stored for the URL key that matches the request, or nil.
+func (c *responseCache) load(key string, r *http.Request) *cacheEntry {
+	for _, variant := range c.variants(key) {
+		if !varyMatches(variant.Vary, r) {
+			continue
+		}
+
+		vk := variantKey(key, variant.Vary)
+
+		p, ok := c.store.Get(vk)
+		if !ok {
+			return nil
+		}
+
+		entry := new(cacheEntry)
+		if err := gob.NewDecoder(bytes.NewReader(p)).Decode(entry); err != nil {
+			c.store.Delete(vk)
+			return nil
+		}
+		return entry
+	}
+	return nil
+}
+
+// save stores the entry as the most recent variant of the URL key, replacing the variant stored for
+// the same values of the Vary headers, and removes the variants beyond the limit.
+func (c *responseCache) save(key string, e *cacheEntry) {
+	buf := new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(e); err != nil {
+		return
+	}
+
+	mu := c.lock(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	c.store.Set(variantKey(key, e.Vary), buf.Bytes())
+
+	variants := []cacheVariant{{Vary: e.Vary}}
+	for _, variant := range c.variants(key) {
+		switch {
+		case maps.Equal(variant.Vary, e.Vary):
+		case len(variants) == maxCacheVariants:
+			c.store.Delete(variantKey(key, variant.Vary))
+		default:
+			variants = append(variants, variant)
+		}
+	}
+
+	buf = new(bytes.Buffer)
+	if err := gob.NewEncoder(buf).Encode(variants); err != nil {
+		return
+	}
+	c.store.Set(key, buf.Bytes())
+}
+
+// delete removes the variants stored for the URL key.
+func (c *responseCache) delete(key string) {
+	mu := c.lock(key)
+	mu.Lock()
+	defer mu.Unlock()
+
+	for _, variant := range c.variants(key) {
+		c.store.Delete(variantKey(key, variant.Vary))
+	}
+	c.store.Delete(key)
+}
+
+// varyMatches reports whether the request has the values of the Vary headers a response was stored for.
+func varyMatches(vary map[string]string, r *http.Request) bool {
+	for name, value := range vary {
+		if strings.Join(r.Header.Values(name), ",") != value {
+			return false
+		}
+	}
+	return true
+}
+
+// age returns the current age of the response (RFC 9111, 4.2.3).
+func (e *cacheEntry) age(now time.Time) time.Duration {
+	date, err := http.ParseTime(e.Header.Get("Date"))
+	if err != nil {
+		date = e.ResponseTime
+	}
+
+	apparent := max(0, e.ResponseTime.Sub(date))
+
+	ageValue, _ := strconv.ParseInt(e.Header.Get("Age"), 10, 64)
+	corrected := time.Duration(ageValue)*time.Second + e.ResponseTime.Sub(e.RequestTime)
+
+	return max(apparent, corrected) + now.Sub(e.ResponseTime)
+}
+
+// lifetime returns the freshness lifetime of the response (RFC 9111, 4.2.1).
+func (e *cacheEntry) lifetime() time.Duration {
+	if maxAge, ok := seconds(parseCacheControl(e.Header), "max-age"); ok {
+		return maxAge
+	}
+
+	date, err := http.ParseTime(e.Header.Get("Date"))
+	if err != nil {
+		date = e.ResponseTime
+	}
+
+	if expires := e.Header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil {
+			return 0
+		}
+		return max(0, t.Sub(date))
+	}
+
+	// A tenth of the time since the last modification is a common heuristic (RFC 9111, 4.2.2).
+	if lastModified, err := http.ParseTime(e.Header.Get("Last-Modified")); err == nil && heuristicallyCacheable[e.StatusCode] {
+		return max(0, date.Sub(lastModified)/10)
+	}
+
+	return 0
+}
+
+// fresh reports whether the response may be served without revalidation for a request with the directives.
+func (e *cacheEntry) fresh(directives map[string]string, header http.Header, now time.Time) bool {
+	if _, ok := directives["no-cache"]; ok || header.Get("Pragma") == "no-cache" {
+		return false
+	}
+
+	stored := parseCacheControl(e.Header)
+	if _, ok := stored["no-cache"]; ok {
+		return false
+	}
+
+	lifetime := e.lifetime()
+	if maxAge, ok := seconds(directives, "max-age"); ok {
+		lifetime = min(lifetime, maxAge)
+	}
+
+	age := e.age(now)
+	if minFresh, ok := seconds(directives, "min-fresh"); ok {
+		age += minFresh
+	}
+
+	if age < lifetime {
+		return true
+	}
+
+	if _, ok := stored["must-revalidate"]; ok {
+		return false
+	}
+	if value, ok := directives["max-stale"]; ok {
+		if value == "" {
+			return true
+		}
+		maxStale, ok := seconds(directives, "max-stale")
+		return ok && age < lifetime+maxStale
+	}
+
+	return false
+}
+
+// conditional returns the request revalidating the response, or r itself if the response has no validators.
+func (e *cacheEntry) conditional(r *http.Request) *http.Request {
+	etag, lastModified := e.Header.Get("ETag"), e.Header.Get("Last-Modified")
+	if etag == "" && lastModified == "" {
+		return r
+	}
+
+	r = r.Clone(r.Context())
+	if etag != "" {
+		r.Header.Set("If-None-Match", etag)
+	}
+	if lastModified != "" {
+		r.Header.Set("If-Modified-Since", lastModified)
+	}
+	return r
+}
+
+// update refreshes the stored response with the header of a 304 Not Modified response (RFC 9111, 4.3.4).
+func (e *cacheEntry) update(h http.Header, requestTime, responseTime time.Time) {
+	for name, values := range h {
+		switch name {
+		case "Content-Length", "Content-Encoding", "Transfer-Encoding", CacheStatusHeader:
+			continue
+		}
+		e.Header[name] = values
+	}
+	e.RequestTime, e.ResponseTime = requestTime, responseTime
+}
+
+// response returns the stored response to the request, with the Age header and the cache status.
+func (e *cacheEntry) response(r *http.Request, status string) *http.Response {
+	h := e.Header.Clone()
+	h.Set("Age", strconv.FormatInt(int64(e.age(time.Now())/time.Second), 10))
+	h.Add(CacheStatusHeader, status)
+
+	response := &http.Response{
+		Status:        strconv.Itoa(e.StatusCode) + " " + http.StatusText(e.StatusCode),
+		StatusCode:    e.StatusCode,
+		Proto:         "HTTP/1.1",
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        h,
+		Body:          io.NopCloser(bytes.NewReader(e.Body)),
+		ContentLength: int64(len(e.Body)),
+		Request:       r,
+	}
+
+	if r.Method == http.MethodHead {
+		response.Body = http.NoBody
+	}
+
+	return response
+}
+
+// cachingBody collects the body while it is read and passes it to done once it is read to the end.
+// A body larger than the limit is not passed, nor is a body closed before the end with more than
+// maxCacheDrainSize bytes left.
+type cachingBody struct {
+	io.ReadCloser
+	buf  bytes.Buffer
+	done func(body []byte)
+	skip bool
+}
+
+func (b *cachingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+
+	if !b.skip {
+		b.buf.Write(p[:n])
+		if b.buf.Len() > maxCacheBodySize {
+			b.skip = true
+			b.buf = bytes.Buffer{}
+		}
+		if err == io.EOF {
+			b.skip = true
+			b.done(b.buf.Bytes())
+		}
+	}
+
+	return n, err
+}
+
+func (b *cachingBody) Close() error {
+	if !b.skip {
+		_, _ = io.Copy(io.Discard, io.LimitReader(b, maxCacheDrainSize))
+		b.skip = true
+	}
+	return b.ReadCloser.Close()
+}
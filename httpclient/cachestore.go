@@ -0,0 +1,114 @@
+package httpclient
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+
+	"github.com/easysy/proton/internal/lru"
+)
+
+// CacheStore stores the encoded responses of the Cache middleware by key.
+// Implementations must be safe for concurrent use.
+type CacheStore interface {
+	// Get returns the value stored under the key and true, or false if there is none.
+	Get(key string) ([]byte, bool)
+	// Set stores the value under the key, replacing the previous one.
+	Set(key string, value []byte)
+	// Delete removes the value stored under the key, if any.
+	Delete(key string)
+}
+
+// MemoryStore is a CacheStore keeping values in memory.
+// When the total size of the values exceeds the limit, the least recently used ones are evicted.
+type MemoryStore struct {
+	cache *lru.Cache[[]byte]
+}
+
+// NewMemoryStore returns a MemoryStore holding at most maxSize bytes of values (64 MiB if maxSize is not positive).
+// A value larger than the limit is not stored.
+func NewMemoryStore(maxSize int64) *MemoryStore {
+	if maxSize <= 0 {
+		maxSize = 64 << 20
+	}
+	return &MemoryStore{cache: lru.New(maxSize, func(v []byte) int64 { return int64(len(v)) }, nil)}
+}
+
+func (s *MemoryStore) Get(key string) ([]byte, bool) {
+	return s.cache.Get(key)
+}
+
+func (s *MemoryStore) Set(key string, value []byte) {
+	s.cache.Set(key, value)
+}
+
+func (s *MemoryStore) Delete(key string) {
+	s.cache.Delete(key)
+}
+
+// Len returns the number of stored values.
+func (s *MemoryStore) Len() int {
+	return s.cache.Len()
+}
+
+// DiskStore is a CacheStore keeping each value in a file of a directory, named by the hash of its key.
+// Values survive restarts of the process; the directory is not limited in size.
+// Failures to access the files are logged and treated as cache misses.
+type DiskStore struct {
+	dir string
+}
+
+// NewDiskStore returns a DiskStore in the directory, which is created if it does not exist.
+func NewDiskStore(dir string) (*DiskStore, error) {
+	if err := os.MkdirAll(dir, 0o700); err != nil {
+		return nil, err
+	}
+	return &DiskStore{dir: dir}, nil
+}
+
+func (s *DiskStore) path(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(s.dir, hex.EncodeToString(sum[:]))
+}
+
+func (s *DiskStore) Get(key string) ([]byte, bool) {
+	value, err := os.ReadFile(s.path(key))
+	if err != nil {
+		if !errors.Is(err, fs.ErrNotExist) {
+			slog.Error("read cache file", "error", err)
+		}
+		return nil, false
+	}
+	return value, true
+}
+
+func (s *DiskStore) Set(key string, value []byte) {
+	// The value is written to a temporary file first, so that a reader never sees a partial value.
+	f, err := os.CreateTemp(s.dir, "tmp-*")
+	if err != nil {
+		slog.Error("create cache file", "error", err)
+		return
+	}
+
+	_, err = f.Write(value)
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(f.Name(), s.path(key))
+	}
+	if err != nil {
+		slog.Error("write cache file", "error", err)
+		_ = os.Remove(f.Name())
+	}
+}
+
+func (s *DiskStore) Delete(key string) {
+	if err := os.Remove(s.path(key)); err != nil && !errors.Is(err, fs.ErrNotExist) {
+		slog.Error("remove cache file", "error", err)
+	}
+}
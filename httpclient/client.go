@@ -15,26 +15,33 @@ type Client interface {
 	// Do delegates directly to the underlying http.Client.Do.
 	Do(req *http.Request) (*http.Response, error)
 	// Request sends an HTTP request based on the given method, URL, and optional body, and returns an HTTP response.
-	// To add additional data to the request, use the optional function f (e.g., for adding headers).
-	Request(ctx context.Context, method, url string, body any, f func(*http.Request)) (*http.Response, error)
+	// A relative URL is joined to the base URL of the client (see WithBaseURL).
+	// To add additional data to the request, use the optional functions opts (e.g., for adding headers);
+	// they are applied after the defaults of the client and may override them.
+	Request(ctx context.Context, method, url string, body any, opts ...RequestOption) (*http.Response, error)
 	// SendFile sends a file as a multipart form upload via an HTTP POST request based on the given URL, key, name and body.
-	// To add additional data to the request, use the optional function f (e.g., for adding headers).
+	// To add additional data to the request, use the optional functions opts (e.g., for adding headers).
 	//   - key: the form field name for the file;
 	//   - name: the name of the file being uploaded.
-	SendFile(ctx context.Context, url, key, filename string, body io.Reader, f func(*http.Request)) (*http.Response, error)
+	SendFile(ctx context.Context, url, key, filename string, body io.Reader, opts ...RequestOption) (*http.Response, error)
 }
 
 type protoClient struct {
 	coder.Coder
 	*http.Client
+	defaults
 }
 
-// New returns a new Client.
-func New(coder coder.Coder, client *http.Client) Client {
-	return &protoClient{Coder: coder, Client: client}
+// New returns a new Client configured by opts.
+func New(coder coder.Coder, client *http.Client, opts ...ClientOption) Client {
+	c := &protoClient{Coder: coder, Client: client}
+	for _, opt := range opts {
+		opt(&c.defaults)
+	}
+	return c
 }
 
-func (c *protoClient) Request(ctx context.Context, method, url string, body any, f func(*http.Request)) (*http.Response, error) {
+func (c *protoClient) Request(ctx context.Context, method, url string, body any, opts ...RequestOption) (*http.Response, error) {
 	var buf io.ReadWriter
 	if body != nil {
 		buf = new(bytes.Buffer)
@@ -43,7 +50,7 @@ func (c *protoClient) Request(ctx context.Context, method, url string, body any,
 		}
 	}
 
-	request, err := http.NewRequestWithContext(ctx, method, url, buf)
+	request, err := c.newRequest(ctx, method, url, buf)
 	if err != nil {
 		return nil, err
 	}
@@ -52,14 +59,12 @@ func (c *protoClient) Request(ctx context.Context, method, url string, body any,
 		request.Header.Set(coder.ContentType, c.ContentType())
 	}
 
-	if f != nil {
-		f(request)
-	}
+	applyOptions(request, opts)
 
 	return c.Do(request)
 }
 
-func (c *protoClient) SendFile(ctx context.Context, url, key, name string, body io.Reader, f func(*http.Request)) (*http.Response, error) {
+func (c *protoClient) SendFile(ctx context.Context, url, key, name string, body io.Reader, opts ...RequestOption) (*http.Response, error) {
 	// Create a buffer for the multipart form
 	var buf bytes.Buffer
 	writer := multipart.NewWriter(&buf)
@@ -80,14 +85,12 @@ func (c *protoClient) SendFile(ctx context.Context, url, key, name string, body
 	}
 
 	var request *http.Request
-	if request, err = http.NewRequestWithContext(ctx, http.MethodPost, url, &buf); err != nil {
+	if request, err = c.newRequest(ctx, http.MethodPost, url, &buf); err != nil {
 		return nil, err
 	}
 	request.Header.Set("Content-Type", writer.FormDataContentType())
 
-	if f != nil {
-		f(request)
-	}
+	applyOptions(request, opts)
 
 	return c.Do(request)
 }
@@ -1,15 +1,27 @@
 package httpclient_test
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"testing"
+	"time"
 
 	"github.com/easysy/proton/coder"
 	"github.com/easysy/proton/httpclient"
+	"github.com/easysy/proton/httpserver"
+	"github.com/easysy/proton/log"
+	"github.com/easysy/proton/metrics"
 )
 
 func equal(t *testing.T, exp, got any) {
@@ -154,3 +166,1140 @@ func TestProtoClient_ContentType(t *testing.T) {
 		})
 	}
 }
+
+func TestDumpHttp_Truncation(t *testing.T) {
+	out := new(bytes.Buffer)
+
+	logger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(out, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	defer slog.SetDefault(logger)
+
+	log.SetMaxBodySize(16)
+	defer log.SetMaxBodySize(64 << 10)
+
+	payload := strings.Repeat("0123456789", 10)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := io.ReadAll(r.Body)
+		equal(t, nil, err)
+		equal(t, payload, string(b))
+
+		w.Header().Set("Content-Type", "text/plain")
+		_, _ = io.WriteString(w, payload)
+	}))
+	defer srv.Close()
+
+	clt := srv.Client()
+	clt.Transport = httpclient.DumpHttp(slog.LevelDebug, true)(clt.Transport)
+
+	resp, err := clt.Post(srv.URL, "text/plain", strings.NewReader(payload))
+	equal(t, nil, err)
+
+	defer func() { _ = resp.Body.Close() }()
+
+	b, err := io.ReadAll(resp.Body)
+	equal(t, nil, err)
+	equal(t, payload, string(b))
+
+	dump := out.String()
+	equal(t, false, strings.Contains(dump, payload))
+	equal(t, 2, strings.Count(dump, "<truncated: showing 16 of 100 bytes>"))
+}
+
+func TestTracer_Propagation(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		parent, ok := log.ParseTraceParent(r.Header.Get("Traceparent"))
+		equal(t, true, ok)
+		equal(t, traceID, parent.TraceID)
+		equal(t, byte(0), parent.Flags)
+		equal(t, "vendor=value", r.Header.Get("Tracestate"))
+		equal(t, traceID, r.Header.Get("X-Request-ID"))
+	}))
+	defer srv.Close()
+
+	clt := srv.Client()
+	clt.Transport = httpclient.Tracer(clt.Transport)
+
+	ctx := context.WithValue(context.Background(), log.TraceCtxKey, traceID)
+	ctx = context.WithValue(ctx, log.TraceParentCtxKey, log.TraceParent{TraceID: traceID, SpanID: "00f067aa0ba902b7"})
+	ctx = context.WithValue(ctx, log.TraceStateCtxKey, "vendor=value")
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+	equal(t, nil, err)
+
+	resp, err := clt.Do(req)
+	equal(t, nil, err)
+	equal(t, nil, resp.Body.Close())
+}
+
+func TestTracer_Spans(t *testing.T) {
+	out := new(bytes.Buffer)
+
+	log.SetSpanExporter(log.NewWriterExporter(out))
+	defer log.SetSpanExporter(nil)
+
+	srv := httptest.NewServer(httpserver.Tracer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})))
+	defer srv.Close()
+
+	clt := srv.Client()
+	clt.Transport = httpclient.Tracer(clt.Transport)
+
+	ctx, parent := log.StartSpan(context.Background(), "parent", log.SpanKindInternal)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL+"/path", nil)
+	equal(t, nil, err)
+
+	resp, err := clt.Do(req)
+	equal(t, nil, err)
+	equal(t, nil, resp.Body.Close())
+
+	parent.End()
+
+	type span struct {
+		TraceID    string         `json:"trace_id"`
+		SpanID     string         `json:"span_id"`
+		ParentID   string         `json:"parent_span_id"`
+		Kind       string         `json:"kind"`
+		Attributes map[string]any `json:"attributes"`
+	}
+
+	spans := make(map[string]span)
+
+	dec := json.NewDecoder(out)
+	for dec.More() {
+		var s span
+		equal(t, nil, dec.Decode(&s))
+		spans[s.Kind] = s
+	}
+
+	equal(t, 3, len(spans))
+
+	client, server := spans["client"], spans["server"]
+	equal(t, parent.TraceID, client.TraceID)
+	equal(t, parent.TraceID, server.TraceID)
+	equal(t, parent.SpanID, client.ParentID)
+	equal(t, client.SpanID, server.ParentID)
+	equal(t, float64(http.StatusNoContent), server.Attributes["http.response.status_code"])
+}
+
+func TestMetrics(t *testing.T) {
+	reg := metrics.NewRegistry()
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	}))
+	defer srv.Close()
+
+	clt := srv.Client()
+	clt.Transport = httpclient.Metrics(&httpclient.MetricsOptions{Registry: reg, Namespace: "test"})(clt.Transport)
+
+	resp, err := clt.Get(srv.URL)
+	equal(t, nil, err)
+	_, err = io.Copy(io.Discard, resp.Body)
+	equal(t, nil, err)
+	equal(t, nil, resp.Body.Close())
+
+	_, err = clt.Get("http://127.0.0.1:0")
+	equal(t, true, err != nil)
+
+	out := new(bytes.Buffer)
+	_, err = reg.WriteTo(out)
+	equal(t, nil, err)
+
+	host := strings.TrimPrefix(srv.URL, "http://")
+	for _, line := range []string{
+		`test_http_client_requests_total{method="GET",host="` + host + `",status="200"} 1`,
+		`test_http_client_requests_total{method="GET",host="127.0.0.1:0",status="error"} 1`,
+		`test_http_client_response_size_bytes_sum{method="GET",host="` + host + `",status="200"} 5`,
+		`test_http_client_response_size_bytes_count{method="GET",host="` + host + `",status="200"} 1`,
+		`test_http_client_requests_in_flight{host="` + host + `"} 0`,
+	} {
+		equal(t, true, strings.Contains(out.String(), line+"\n"))
+	}
+}
+
+func TestSwitchingProtocols(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			return
+		}
+		defer func() { _ = conn.Close() }()
+
+		_, _ = rw.WriteString("HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: echo\r\n\r\n")
+		_ = rw.Flush()
+
+		// echo one line
+		line, _ := rw.ReadString('\n')
+		_, _ = rw.WriteString(line)
+		_ = rw.Flush()
+	}))
+	defer srv.Close()
+
+	clt := &http.Client{Transport: httpclient.RoundTripperSequencer(
+		http.DefaultTransport,
+		httpclient.Hedge(time.Second, 2),
+		httpclient.Balance(&httpclient.BalanceOptions{Service: "echo", Endpoints: []string{srv.URL}}),
+		httpclient.Metrics(&httpclient.MetricsOptions{Registry: metrics.NewRegistry()}),
+	)}
+
+	r, err := http.NewRequest(http.MethodGet, "http://echo/", nil)
+	equal(t, nil, err)
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Upgrade", "echo")
+
+	resp, err := clt.Do(r)
+	equal(t, nil, err)
+	equal(t, http.StatusSwitchingProtocols, resp.StatusCode)
+
+	// The body of the upgraded connection is still writable through the middlewares.
+	rwc, ok := resp.Body.(io.ReadWriteCloser)
+	equal(t, true, ok)
+
+	_, err = io.WriteString(rwc, "ping\n")
+	equal(t, nil, err)
+
+	b := make([]byte, 5)
+	_, err = io.ReadFull(rwc, b)
+	equal(t, nil, err)
+	equal(t, "ping\n", string(b))
+	equal(t, nil, rwc.Close())
+}
+
+func TestCompressRequest(t *testing.T) {
+	srv := httptest.NewServer(httpserver.Decompress(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = io.Copy(w, r.Body)
+	})))
+	defer srv.Close()
+
+	var encodings []string
+
+	clt := srv.Client()
+	clt.Transport = httpclient.RoundTripperSequencer(clt.Transport,
+		func(next http.RoundTripper) http.RoundTripper {
+			return httpclient.RoundTripper(func(r *http.Request) (*http.Response, error) {
+				encodings = append(encodings, r.Header.Get("Content-Encoding"))
+				return next.RoundTrip(r)
+			})
+		},
+		httpclient.CompressRequest(&httpclient.CompressOptions{MinSize: 16}),
+	)
+
+	for _, body := range []string{"short", strings.Repeat("compressible ", 10)} {
+		resp, err := clt.Post(srv.URL, "text/plain", strings.NewReader(body))
+		equal(t, nil, err)
+
+		b, err := io.ReadAll(resp.Body)
+		equal(t, nil, err)
+		equal(t, nil, resp.Body.Close())
+		equal(t, body, string(b))
+	}
+
+	equal(t, []string{"", "gzip"}, encodings)
+}
+
+func TestCache(t *testing.T) {
+	var requests []string
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.URL.Path+" "+r.Header.Get("If-None-Match"))
+
+		switch r.URL.Path {
+		case "/fresh":
+			w.Header().Set("Cache-Control", "max-age=60")
+		case "/revalidate":
+			w.Header().Set("Cache-Control", "no-cache")
+			w.Header().Set("ETag", `"v1"`)
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+		case "/vary":
+			w.Header().Set("Cache-Control", "max-age=60")
+			w.Header().Set("Vary", "Accept-Language")
+		case "/no-store":
+			w.Header().Set("Cache-Control", "no-store")
+		case "/items":
+			w.Header().Set("Location", "/fresh")
+			w.Header().Set("Content-Location", "http://"+r.Host+"/vary")
+		}
+		_, _ = w.Write([]byte("body of " + r.URL.Path + " " + r.Header.Get("Accept-Language")))
+	}))
+	defer srv.Close()
+
+	clt := srv.Client()
+	clt.Transport = httpclient.Cache(httpclient.NewMemoryStore(0))(clt.Transport)
+
+	var tests = []struct {
+		method   string
+		path     string
+		language string
+		status   string
+		body     string
+	}{
+		{method: http.MethodGet, path: "/fresh", status: httpclient.CacheMiss, body: "body of /fresh "},
+		{method: http.MethodGet, path: "/fresh", status: httpclient.CacheHit, body: "body of /fresh "},
+		{method: http.MethodPost, path: "/fresh", body: "body of /fresh "},
+		{method: http.MethodGet, path: "/fresh", status: httpclient.CacheMiss, body: "body of /fresh "},
+		{method: http.MethodGet, path: "/revalidate", status: httpclient.CacheMiss, body: "body of /revalidate "},
+		{method: http.MethodGet, path: "/revalidate", status: httpclient.CacheRevalidated, body: "body of /revalidate "},
+		{method: http.MethodGet, path: "/vary", language: "en", status: httpclient.CacheMiss, body: "body of /vary en"},
+		{method: http.MethodGet, path: "/vary", language: "en", status: httpclient.CacheHit, body: "body of /vary en"},
+		{method: http.MethodGet, path: "/vary", language: "de", status: httpclient.CacheMiss, body: "body of /vary de"},
+		{method: http.MethodGet, path: "/vary", language: "en", status: httpclient.CacheHit, body: "body of /vary en"},
+		{method: http.MethodGet, path: "/vary", language: "de", status: httpclient.CacheHit, body: "body of /vary de"},
+		{method: http.MethodGet, path: "/no-store", status: httpclient.CacheMiss, body: "body of /no-store "},
+		{method: http.MethodGet, path: "/no-store", status: httpclient.CacheMiss, body: "body of /no-store "},
+		{method: http.MethodGet, path: "/fresh", status: httpclient.CacheHit, body: "body of /fresh "},
+		{method: http.MethodPost, path: "/items", body: "body of /items "},
+		{method: http.MethodGet, path: "/fresh", status: httpclient.CacheMiss, body: "body of /fresh "},
+		{method: http.MethodGet, path: "/vary", language: "en", status: httpclient.CacheMiss, body: "body of /vary en"},
+	}
+
+	for _, test := range tests {
+		r, err := http.NewRequest(test.method, srv.URL+test.path, nil)
+		equal(t, nil, err)
+		if test.language != "" {
+			r.Header.Set("Accept-Language", test.language)
+		}
+
+		resp, err := clt.Do(r)
+		equal(t, nil, err)
+
+		b, err := io.ReadAll(resp.Body)
+		equal(t, nil, err)
+		equal(t, nil, resp.Body.Close())
+
+		equal(t, http.StatusOK, resp.StatusCode)
+		equal(t, test.status, httpclient.CacheStatus(resp))
+		equal(t, test.body, string(b))
+	}
+
+	equal(t, []string{
+		"/fresh ",
+		"/fresh ",
+		"/fresh ",
+		"/revalidate ",
+		`/revalidate "v1"`,
+		"/vary ",
+		"/vary ",
+		"/no-store ",
+		"/no-store ",
+		"/items ",
+		"/fresh ",
+		"/vary ",
+	}, requests)
+}
+
+// readCountingStore counts the bytes of the values returned by Get.
+type readCountingStore struct {
+	*httpclient.MemoryStore
+	read atomic.Int64
+}
+
+func (s *readCountingStore) Get(key string) ([]byte, bool) {
+	value, ok := s.MemoryStore.Get(key)
+	s.read.Add(int64(len(value)))
+	return value, ok
+}
+
+func TestCache_Variants(t *testing.T) {
+	const variants = 8
+
+	var arrived sync.WaitGroup
+	arrived.Add(variants)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The concurrent misses are answered together, so that their responses are stored concurrently.
+		arrived.Done()
+		arrived.Wait()
+
+		w.Header().Set("Cache-Control", "max-age=60")
+		w.Header().Set("Vary", "Accept-Language")
+		_, _ = w.Write([]byte(r.Header.Get("Accept-Language") + strings.Repeat(" ", 10<<10)))
+	}))
+	defer srv.Close()
+
+	store := &readCountingStore{MemoryStore: httpclient.NewMemoryStore(0)}
+
+	clt := srv.Client()
+	clt.Transport = httpclient.Cache(store)(clt.Transport)
+
+	get := func(language string) (string, string) {
+		r, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+		if err != nil {
+			return "", err.Error()
+		}
+		r.Header.Set("Accept-Language", language)
+
+		resp, err := clt.Do(r)
+		if err != nil {
+			return "", err.Error()
+		}
+		defer func() { _ = resp.Body.Close() }()
+
+		b, _ := io.ReadAll(resp.Body)
+		return strings.TrimSpace(string(b)), httpclient.CacheStatus(resp)
+	}
+
+	var wg sync.WaitGroup
+	for i := range variants {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			get(strconv.Itoa(i))
+		}()
+	}
+	wg.Wait()
+
+	// No concurrent miss has dropped the variant of another one, and a hit reads one body only.
+	for i := range variants {
+		store.read.Store(0)
+
+		body, status := get(strconv.Itoa(i))
+		equal(t, strconv.Itoa(i), body)
+		equal(t, httpclient.CacheHit, status)
+		equal(t, true, store.read.Load() < 2*(10<<10))
+	}
+}
+
+func TestCache_ClosedBody(t *testing.T) {
+	var calls atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		w.Header().Set("Cache-Control", "max-age=60")
+		_, _ = w.Write([]byte(strings.Repeat("a", 1000)))
+	}))
+	defer srv.Close()
+
+	clt := srv.Client()
+	clt.Transport = httpclient.Cache(httpclient.NewMemoryStore(0))(clt.Transport)
+
+	resp, err := clt.Get(srv.URL)
+	equal(t, nil, err)
+
+	// The caller stops reading early; the rest of the small body is read on Close to store the response.
+	_, err = resp.Body.Read(make([]byte, 10))
+	equal(t, nil, err)
+	equal(t, nil, resp.Body.Close())
+
+	resp, err = clt.Get(srv.URL)
+	equal(t, nil, err)
+
+	b, err := io.ReadAll(resp.Body)
+	equal(t, nil, err)
+	equal(t, nil, resp.Body.Close())
+
+	equal(t, httpclient.CacheHit, httpclient.CacheStatus(resp))
+	equal(t, 1000, len(b))
+	equal(t, int32(1), calls.Load())
+}
+
+func TestMemoryStore(t *testing.T) {
+	store := httpclient.NewMemoryStore(10)
+
+	store.Set("a", []byte("1234"))
+	store.Set("b", []byte("1234"))
+
+	_, ok := store.Get("a")
+	equal(t, true, ok)
+
+	// "b" is the least recently used value and is evicted.
+	store.Set("c", []byte("1234"))
+
+	_, ok = store.Get("b")
+	equal(t, false, ok)
+	equal(t, 2, store.Len())
+
+	store.Set("d", []byte("too large value"))
+
+	_, ok = store.Get("d")
+	equal(t, false, ok)
+}
+
+func TestDiskStore(t *testing.T) {
+	store, err := httpclient.NewDiskStore(t.TempDir())
+	equal(t, nil, err)
+
+	_, ok := store.Get("key")
+	equal(t, false, ok)
+
+	store.Set("key", []byte("value"))
+
+	value, ok := store.Get("key")
+	equal(t, true, ok)
+	equal(t, "value", string(value))
+
+	store.Delete("key")
+
+	_, ok = store.Get("key")
+	equal(t, false, ok)
+}
+
+func TestCoalesce(t *testing.T) {
+	var calls atomic.Int32
+
+	release := make(chan struct{})
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls.Add(1)
+		<-release
+		_, _ = w.Write([]byte("shared " + r.Header.Get("X-Tenant")))
+	}))
+	defer srv.Close()
+
+	clt := srv.Client()
+	clt.Transport = httpclient.Coalesce(clt.Transport)
+
+	get := func(ctx context.Context, tenant string) (string, error) {
+		r, err := http.NewRequestWithContext(ctx, http.MethodGet, srv.URL, nil)
+		if err != nil {
+			return "", err
+		}
+		r.Header.Set("X-Tenant", tenant)
+
+		resp, err := clt.Do(r)
+		if err != nil {
+			return "", err
+		}
+		defer func() { _ = resp.Body.Close() }()
+
+		b, err := io.ReadAll(resp.Body)
+		return string(b), err
+	}
+
+	// A canceled caller does not cancel the call of the others.
+	canceled, cancel := context.WithCancel(context.Background())
+
+	var wg sync.WaitGroup
+
+	bodies := make([]string, 6)
+	errs := make([]error, 6)
+	for i := range bodies {
+		tenant := "a"
+		if i == len(bodies)-1 {
+			tenant = "b"
+		}
+		ctx := context.Background()
+		if i == 0 {
+			ctx = canceled
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			bodies[i], errs[i] = get(ctx, tenant)
+		}()
+	}
+
+	time.Sleep(50 * time.Millisecond)
+	cancel()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	equal(t, int32(2), calls.Load())
+	equal(t, true, errors.Is(errs[0], context.Canceled))
+	equal(t, []string{"", "shared a", "shared a", "shared a", "shared a", "shared b"}, bodies)
+}
+
+func TestCoalesce_NotShared(t *testing.T) {
+	large := strings.Repeat("x", 2<<20)
+
+	var tests = []struct {
+		name        string
+		contentType string
+		body        string
+	}{
+		{name: "large body", contentType: "text/plain", body: large},
+		{name: "event stream", contentType: "text/event-stream; charset=utf-8", body: "data: event\n\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var calls atomic.Int32
+
+			release := make(chan struct{})
+
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if calls.Add(1) == 1 {
+					<-release
+				}
+				w.Header().Set("Content-Type", test.contentType)
+				_, _ = io.WriteString(w, test.body)
+			}))
+			defer srv.Close()
+
+			clt := srv.Client()
+			clt.Transport = httpclient.Coalesce(clt.Transport)
+
+			var wg sync.WaitGroup
+
+			bodies := make([]string, 3)
+			for i := range bodies {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+
+					resp, err := clt.Get(srv.URL)
+					if err != nil {
+						t.Error(err)
+						return
+					}
+					defer func() { _ = resp.Body.Close() }()
+
+					b, _ := io.ReadAll(resp.Body)
+					bodies[i] = string(b)
+				}()
+			}
+
+			time.Sleep(50 * time.Millisecond)
+			close(release)
+			wg.Wait()
+
+			// The first caller gets the response of the shared call, the others send their own requests.
+			equal(t, int32(3), calls.Load())
+			equal(t, []string{test.body, test.body, test.body}, bodies)
+		})
+	}
+}
+
+func TestHedge(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	out := new(bytes.Buffer)
+
+	logger := slog.Default()
+	slog.SetDefault(slog.New(log.TraceHandler{Handler: slog.NewTextHandler(out, nil)}))
+	defer slog.SetDefault(logger)
+
+	var (
+		attempts atomic.Int32
+		canceled = make(chan struct{}, 1)
+	)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, _ := io.ReadAll(r.Body)
+
+		switch attempts.Add(1) {
+		case 1:
+			// The slow attempt is canceled once another one wins.
+			<-r.Context().Done()
+			canceled <- struct{}{}
+			return
+		case 2:
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write(b)
+	}))
+	defer srv.Close()
+
+	clt := srv.Client()
+	clt.Transport = httpclient.HedgeWithOptions(&httpclient.HedgeOptions{
+		Delay:    20 * time.Millisecond,
+		Attempts: 3,
+		Methods:  []string{http.MethodPut},
+	})(clt.Transport)
+
+	ctx := log.WithTraceID(context.Background(), traceID)
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, srv.URL, strings.NewReader("payload"))
+	equal(t, nil, err)
+
+	resp, err := clt.Do(req)
+	equal(t, nil, err)
+
+	b, err := io.ReadAll(resp.Body)
+	equal(t, nil, err)
+	equal(t, nil, resp.Body.Close())
+
+	equal(t, http.StatusOK, resp.StatusCode)
+	equal(t, "payload", string(b))
+	equal(t, int32(3), attempts.Load())
+
+	select {
+	case <-canceled:
+	case <-time.After(time.Second):
+		t.Fatal("the slow attempt was not canceled")
+	}
+
+	equal(t, true, strings.Contains(out.String(), "msg=\"hedged request\""))
+	equal(t, true, strings.Contains(out.String(), "attempt=3 attempts=3"))
+	equal(t, true, strings.Contains(out.String(), traceID))
+}
+
+func TestHedge_NotHedged(t *testing.T) {
+	var attempts atomic.Int32
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		attempts.Add(1)
+		time.Sleep(30 * time.Millisecond)
+	}))
+	defer srv.Close()
+
+	var tests = []struct {
+		name   string
+		hedge  func(http.RoundTripper) http.RoundTripper
+		method string
+	}{
+		{name: "PUT by default", hedge: httpclient.Hedge(time.Millisecond, 3), method: http.MethodPut},
+		{name: "DELETE by default", hedge: httpclient.Hedge(time.Millisecond, 3), method: http.MethodDelete},
+		{name: "zero delay", hedge: httpclient.Hedge(0, 3), method: http.MethodGet},
+		{name: "negative delay", hedge: httpclient.Hedge(-time.Second, 3), method: http.MethodGet},
+		{name: "one attempt", hedge: httpclient.Hedge(time.Millisecond, 1), method: http.MethodGet},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			attempts.Store(0)
+
+			clt := &http.Client{Transport: test.hedge(http.DefaultTransport)}
+
+			req, err := http.NewRequest(test.method, srv.URL, nil)
+			equal(t, nil, err)
+
+			resp, err := clt.Do(req)
+			equal(t, nil, err)
+			equal(t, nil, resp.Body.Close())
+
+			equal(t, int32(1), attempts.Load())
+		})
+	}
+}
+
+func TestBalance(t *testing.T) {
+	var endpoints []string
+	for i := range 3 {
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if i == 2 && r.URL.Query().Get("fail") != "" {
+				w.WriteHeader(http.StatusBadGateway)
+			}
+			_, _ = w.Write([]byte(strconv.Itoa(i) + " " + r.URL.Path))
+		}))
+		defer srv.Close()
+		endpoints = append(endpoints, srv.URL+"/api")
+	}
+
+	get := func(clt *http.Client, url string) string {
+		resp, err := clt.Get(url)
+		equal(t, nil, err)
+
+		b, err := io.ReadAll(resp.Body)
+		equal(t, nil, err)
+		equal(t, nil, resp.Body.Close())
+		return string(b)
+	}
+
+	newClient := func(opts *httpclient.BalanceOptions) *http.Client {
+		return &http.Client{Transport: httpclient.Balance(opts)(http.DefaultTransport)}
+	}
+
+	t.Run("round robin", func(t *testing.T) {
+		clt := newClient(&httpclient.BalanceOptions{Service: "users", Endpoints: endpoints})
+
+		counts := make(map[string]int)
+		for range 9 {
+			counts[get(clt, "http://users/v1/users")]++
+		}
+		equal(t, map[string]int{"0 /api/v1/users": 3, "1 /api/v1/users": 3, "2 /api/v1/users": 3}, counts)
+	})
+
+	t.Run("least in flight", func(t *testing.T) {
+		clt := newClient(&httpclient.BalanceOptions{Service: "users", Endpoints: endpoints, Strategy: httpclient.BalanceLeastInFlight})
+
+		// Responses that are not closed keep their endpoints busy.
+		seen := make(map[string]bool)
+		for range 3 {
+			resp, err := clt.Get("http://users/")
+			equal(t, nil, err)
+			defer func() { _ = resp.Body.Close() }()
+
+			b, err := io.ReadAll(resp.Body)
+			equal(t, nil, err)
+			seen[string(b)] = true
+		}
+		equal(t, 3, len(seen))
+	})
+
+	t.Run("consistent hash", func(t *testing.T) {
+		clt := newClient(&httpclient.BalanceOptions{Service: "users", Endpoints: endpoints, Strategy: httpclient.BalanceConsistentHash})
+
+		seen := make(map[string]bool)
+		for i := range 30 {
+			path := "/users/" + strconv.Itoa(i)
+			first := get(clt, "http://users"+path)
+			equal(t, first, get(clt, "http://users"+path))
+			seen[first[:1]] = true
+		}
+		equal(t, 3, len(seen))
+	})
+
+	t.Run("ejection", func(t *testing.T) {
+		clt := newClient(&httpclient.BalanceOptions{Service: "users", Endpoints: endpoints, MaxFailures: 2, EjectionTime: time.Hour})
+
+		for range 6 {
+			get(clt, "http://users/?fail=1")
+		}
+
+		counts := make(map[string]int)
+		for range 6 {
+			counts[get(clt, "http://users/?fail=1")]++
+		}
+		equal(t, map[string]int{"0 /api/": 3, "1 /api/": 3}, counts)
+	})
+
+	t.Run("resolver and other hosts", func(t *testing.T) {
+		clt := newClient(&httpclient.BalanceOptions{
+			Service: "users",
+			Resolver: func(context.Context) ([]string, error) {
+				return endpoints[1:2], nil
+			},
+		})
+
+		equal(t, "1 /api/", get(clt, "http://USERS/"))
+		equal(t, "0 /", get(clt, strings.TrimSuffix(endpoints[0], "/api")+"/"))
+	})
+
+	t.Run("resolver returning no endpoints", func(t *testing.T) {
+		var calls atomic.Int32
+
+		clt := newClient(&httpclient.BalanceOptions{
+			Service: "users",
+			Resolver: func(context.Context) ([]string, error) {
+				if calls.Add(1) == 1 {
+					return endpoints[1:2], nil
+				}
+				return nil, nil
+			},
+			ResolveInterval: 10 * time.Millisecond,
+		})
+
+		equal(t, "1 /api/", get(clt, "http://users/"))
+
+		time.Sleep(20 * time.Millisecond)
+		equal(t, "1 /api/", get(clt, "http://users/")) // refreshes in the background
+
+		time.Sleep(20 * time.Millisecond)
+		equal(t, "1 /api/", get(clt, "http://users/"))
+		equal(t, true, calls.Load() >= 2)
+	})
+
+	t.Run("failing resolver", func(t *testing.T) {
+		var calls atomic.Int32
+
+		clt := newClient(&httpclient.BalanceOptions{
+			Service: "users",
+			Resolver: func(context.Context) ([]string, error) {
+				calls.Add(1)
+				time.Sleep(20 * time.Millisecond)
+				return nil, errors.New("registry unavailable")
+			},
+		})
+
+		// Concurrent requests share one call, and the next ones fail fast until the retry delay has passed.
+		var wg sync.WaitGroup
+		for range 10 {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, err := clt.Get("http://users/")
+				if !errors.Is(err, httpclient.ErrNoEndpoints) {
+					t.Error(err)
+				}
+			}()
+		}
+		wg.Wait()
+
+		_, err := clt.Get("http://users/")
+		equal(t, true, errors.Is(err, httpclient.ErrNoEndpoints))
+		equal(t, int32(1), calls.Load())
+	})
+}
+
+func TestProtoClient_Options(t *testing.T) {
+	type echo struct {
+		URL       string `json:"url"`
+		Tenant    string `json:"tenant"`
+		UserAgent string `json:"user_agent"`
+	}
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(&echo{URL: r.URL.String(), Tenant: r.Header.Get("X-Tenant"), UserAgent: r.Header.Get("User-Agent")})
+	}))
+	defer srv.Close()
+
+	clt := httpclient.New(cdrJSON, srv.Client(),
+		httpclient.WithBaseURL(srv.URL+"/v1"),
+		httpclient.WithHeader("X-Tenant", "default"),
+		httpclient.WithUserAgent("proton-test/1.0"),
+		httpclient.WithQuery("lang", "en"),
+	)
+
+	var tests = []struct {
+		name string
+		url  string
+		opts []httpclient.RequestOption
+		out  *echo
+	}{
+		{
+			name: "relative path",
+			url:  "users/1",
+			out:  &echo{URL: "/v1/users/1?lang=en", Tenant: "default", UserAgent: "proton-test/1.0"},
+		},
+		{
+			name: "absolute path and query",
+			url:  "/users?lang=de&page=2",
+			out:  &echo{URL: "/v1/users?lang=de&page=2", Tenant: "default", UserAgent: "proton-test/1.0"},
+		},
+		{
+			name: "absolute URL",
+			url:  srv.URL + "/health",
+			out:  &echo{URL: "/health?lang=en", Tenant: "default", UserAgent: "proton-test/1.0"},
+		},
+		{
+			name: "request options",
+			url:  "users",
+			opts: []httpclient.RequestOption{
+				httpclient.Header("X-Tenant", "acme"),
+				httpclient.Query("page", "3"),
+				nil,
+				func(r *http.Request) { r.Header.Set("User-Agent", "custom") },
+			},
+			out: &echo{URL: "/v1/users?lang=en&page=3", Tenant: "acme", UserAgent: "custom"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			resp, err := clt.Request(context.Background(), http.MethodGet, test.url, nil, test.opts...)
+			equal(t, nil, err)
+
+			defer func() { _ = resp.Body.Close() }()
+
+			out := new(echo)
+			equal(t, nil, clt.Decode(context.Background(), resp.Body, out))
+			equal(t, test.out, out)
+		})
+	}
+
+	// A scheme relative URL keeps its host and gets the scheme of the base URL.
+	host := "localhost:" + strings.TrimPrefix(srv.URL, "http://127.0.0.1:")
+
+	resp, err := clt.Request(context.Background(), http.MethodGet, "//"+host+"/health", nil)
+	equal(t, nil, err)
+	equal(t, nil, resp.Body.Close())
+	equal(t, "http://"+host+"/health?lang=en", resp.Request.URL.String())
+}
+
+func TestStaticAuth(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(r.Header.Get("Authorization") + "|" + r.Header.Get("X-API-Key")))
+	}))
+	defer srv.Close()
+
+	var tests = []struct {
+		name   string
+		auth   func(http.RoundTripper) http.RoundTripper
+		header http.Header
+		out    string
+	}{
+		{
+			name: "bearer",
+			auth: httpclient.BearerAuth("secret"),
+			out:  "Bearer secret|",
+		},
+		{
+			name: "basic",
+			auth: httpclient.BasicAuth("user", "pass"),
+			out:  "Basic dXNlcjpwYXNz|",
+		},
+		{
+			name: "api key",
+			auth: httpclient.APIKeyAuth("X-API-Key", "key"),
+			out:  "|key",
+		},
+		{
+			name:   "request header",
+			auth:   httpclient.BearerAuth("secret"),
+			header: http.Header{"Authorization": {"Bearer own"}},
+			out:    "Bearer own|",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			clt := &http.Client{Transport: test.auth(http.DefaultTransport)}
+
+			r, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+			equal(t, nil, err)
+			for k, v := range test.header {
+				r.Header[k] = v
+			}
+
+			resp, err := clt.Do(r)
+			equal(t, nil, err)
+
+			b, err := io.ReadAll(resp.Body)
+			equal(t, nil, err)
+			equal(t, nil, resp.Body.Close())
+			equal(t, test.out, string(b))
+		})
+	}
+}
+
+func TestOAuth2(t *testing.T) {
+	var (
+		issued  atomic.Int32
+		current atomic.Value
+	)
+
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		id, secret, _ := r.BasicAuth()
+		if id != "client" || secret != "s3cret" || r.FormValue("grant_type") != "client_credentials" {
+			w.WriteHeader(http.StatusUnauthorized)
+			_, _ = w.Write([]byte(`{"error":"invalid_client"}`))
+			return
+		}
+		equal(t, "read write", r.FormValue("scope"))
+
+		// Slow token requests let concurrent callers pile up.
+		time.Sleep(20 * time.Millisecond)
+
+		token := "token-" + strconv.Itoa(int(issued.Add(1)))
+		current.Store(token)
+
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"` + token + `","token_type":"bearer","expires_in":3600}`))
+	}))
+	defer tokens.Close()
+
+	api := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer "+current.Load().(string) {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		b, _ := io.ReadAll(r.Body)
+		_, _ = w.Write(b)
+	}))
+	defer api.Close()
+
+	credentials := &httpclient.ClientCredentials{
+		TokenURL:     tokens.URL,
+		ClientID:     "client",
+		ClientSecret: "s3cret",
+		Scopes:       []string{"read", "write"},
+	}
+
+	clt := &http.Client{Transport: httpclient.OAuth2(credentials)(http.DefaultTransport)}
+
+	post := func(body string) (string, error) {
+		resp, err := clt.Post(api.URL, "text/plain", strings.NewReader(body))
+		if err != nil {
+			return "", err
+		}
+		defer func() { _ = resp.Body.Close() }()
+
+		b, err := io.ReadAll(resp.Body)
+		if err == nil && resp.StatusCode != http.StatusOK {
+			err = errors.New(resp.Status)
+		}
+		return string(b), err
+	}
+
+	// Concurrent requests share one token request.
+	type result struct {
+		body string
+		err  error
+	}
+
+	results := make(chan result, 10)
+	for range 10 {
+		go func() {
+			body, err := post("hello")
+			results <- result{body: body, err: err}
+		}()
+	}
+	for range 10 {
+		equal(t, result{body: "hello"}, <-results)
+	}
+
+	equal(t, int32(1), issued.Load())
+
+	// A revoked token is replaced once, and the request is retried with the new one.
+	current.Store("revoked")
+
+	body, err := post("again")
+	equal(t, nil, err)
+	equal(t, "again", body)
+	equal(t, int32(2), issued.Load())
+
+	// Invalid credentials fail with the error of the token endpoint.
+	credentials = &httpclient.ClientCredentials{TokenURL: tokens.URL, ClientID: "client", ClientSecret: "wrong"}
+
+	_, err = credentials.Token(context.Background())
+
+	var tokenErr *httpclient.TokenError
+	equal(t, true, errors.As(err, &tokenErr))
+	equal(t, "invalid_client", tokenErr.Code)
+}
+
+func TestClientCredentials_Refresh(t *testing.T) {
+	var (
+		requests atomic.Int32
+		failing  atomic.Bool
+	)
+
+	tokens := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		n := requests.Add(1)
+		if failing.Load() {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"token-` + strconv.Itoa(int(n)) + `","expires_in":1}`))
+	}))
+	defer tokens.Close()
+
+	credentials := &httpclient.ClientCredentials{TokenURL: tokens.URL, ClientID: "client", ClientSecret: "s3cret"}
+	ctx := context.Background()
+
+	token, err := credentials.Token(ctx)
+	equal(t, nil, err)
+	equal(t, "token-1", token.AccessToken)
+
+	failing.Store(true)
+
+	// Half of the lifetime later the token is refreshed in the background, and served meanwhile.
+	time.Sleep(600 * time.Millisecond)
+
+	token, err = credentials.Token(ctx)
+	equal(t, nil, err)
+	equal(t, "token-1", token.AccessToken)
+
+	for requests.Load() < 2 {
+		time.Sleep(5 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+
+	// The refresh has failed: the token is still served, and no request is sent during the backoff.
+	token, err = credentials.Token(ctx)
+	equal(t, nil, err)
+	equal(t, "token-1", token.AccessToken)
+	equal(t, int32(2), requests.Load())
+
+	// Once the token has expired, the error of the last request is returned until the backoff has passed.
+	time.Sleep(500 * time.Millisecond)
+
+	_, err = credentials.Token(ctx)
+
+	var tokenErr *httpclient.TokenError
+	equal(t, true, errors.As(err, &tokenErr))
+	equal(t, http.StatusServiceUnavailable, tokenErr.StatusCode)
+	equal(t, int32(2), requests.Load())
+}
@@ -0,0 +1,160 @@
+package httpclient
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// maxCoalesceBodySize is the size of the largest response body shared by Coalesce.
+const maxCoalesceBodySize = 1 << 20
+
+// Coalesce merges concurrent identical GET and HEAD requests without a body into one call of the next
+// http.RoundTripper. Requests are identical if they have the same method, URL and headers.
+// The response body is read into memory, and every caller gets its own copy of the response with an
+// independent body, which it must close as usual.
+//
+// Responses with a body larger than 1 MiB and event streams ("text/event-stream") are not shared:
+// the first caller gets the response streamed as it is received, and the others send their own requests.
+//
+// The shared call is canceled only when all the callers waiting for it are canceled;
+// it carries the values of the context of the first caller, e.g. its trace ID.
+func Coalesce(next http.RoundTripper) http.RoundTripper {
+	var (
+		mu    sync.Mutex
+		calls = make(map[string]*coalescedCall)
+	)
+
+	return RoundTripper(func(r *http.Request) (*http.Response, error) {
+		if (r.Method != http.MethodGet && r.Method != http.MethodHead) || (r.Body != nil && r.Body != http.NoBody) {
+			return next.RoundTrip(r)
+		}
+
+		key := coalesceKey(r)
+
+		mu.Lock()
+		c, ok := calls[key]
+		if !ok {
+			ctx, cancel := context.WithCancel(context.WithoutCancel(r.Context()))
+			c = &coalescedCall{done: make(chan struct{}), cancel: cancel}
+			calls[key] = c
+
+			go func() {
+				c.do(next, r.Clone(ctx))
+
+				// A response that is not shared is canceled by its caller closing the body.
+				if c.stream == nil {
+					cancel()
+				}
+
+				mu.Lock()
+				if calls[key] == c {
+					delete(calls, key)
+				}
+				mu.Unlock()
+
+				close(c.done)
+			}()
+		}
+		c.waiters++
+		mu.Unlock()
+
+		select {
+		case <-c.done:
+			return c.result(next, r)
+		case <-r.Context().Done():
+			mu.Lock()
+			if c.waiters--; c.waiters == 0 {
+				// Nobody waits for the call any more: cancel it and let new requests start another one.
+				if calls[key] == c {
+					delete(calls, key)
+				}
+				c.cancel()
+			}
+			mu.Unlock()
+			return nil, r.Context().Err()
+		}
+	})
+}
+
+// coalesceKey returns the key of the requests identical to r.
+func coalesceKey(r *http.Request) string {
+	var b strings.Builder
+	b.WriteString(r.Method + " " + r.URL.String() + "\n")
+	_ = r.Header.Write(&b) // The headers are written sorted by name.
+	return b.String()
+}
+
+// coalescedCall is a call shared by identical requests.
+type coalescedCall struct {
+	done    chan struct{}
+	cancel  context.CancelFunc
+	waiters int // guarded by the mutex of Coalesce
+
+	response *http.Response
+	body     []byte
+	err      error
+
+	// stream is the body of a response too large to be shared, taken by the first caller.
+	stream  io.ReadCloser
+	claimed atomic.Bool
+}
+
+// do sends the request and reads the response body, unless it is too large or is an event stream.
+func (c *coalescedCall) do(next http.RoundTripper, r *http.Request) {
+	c.response, c.err = next.RoundTrip(r)
+	if c.err != nil {
+		return
+	}
+
+	body := c.response.Body
+
+	mediaType, _, _ := strings.Cut(c.response.Header.Get("Content-Type"), ";")
+	if c.response.ContentLength > maxCoalesceBodySize || strings.EqualFold(strings.TrimSpace(mediaType), "text/event-stream") {
+		c.stream = body
+		return
+	}
+
+	c.body, c.err = io.ReadAll(io.LimitReader(body, maxCoalesceBodySize+1))
+	if c.err == nil && len(c.body) > maxCoalesceBodySize {
+		c.stream = struct {
+			io.Reader
+			io.Closer
+		}{io.MultiReader(bytes.NewReader(c.body), body), body}
+		c.body = nil
+		return
+	}
+
+	if err := body.Close(); c.err == nil {
+		c.err = err
+	}
+}
+
+// result returns a copy of the response of the call for the request.
+// A response that is not shared is returned to the first caller only; the others send their own request.
+func (c *coalescedCall) result(next http.RoundTripper, r *http.Request) (*http.Response, error) {
+	if c.err != nil {
+		return nil, c.err
+	}
+
+	if c.stream != nil && !c.claimed.CompareAndSwap(false, true) {
+		return next.RoundTrip(r)
+	}
+
+	response := *c.response
+	response.Header = c.response.Header.Clone()
+	response.Trailer = c.response.Trailer.Clone()
+	response.Body = io.NopCloser(bytes.NewReader(c.body))
+	if c.stream != nil {
+		// The trailer is filled when the body is read to the end.
+		response.Trailer = c.response.Trailer
+		response.Body = &cancelBody{ReadCloser: c.stream, cancel: c.cancel}
+	}
+	response.Request = r
+
+	return &response, nil
+}
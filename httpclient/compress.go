@@ -0,0 +1,110 @@
+package httpclient
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// CompressOptions represents configuration for the CompressRequest middleware.
+type CompressOptions struct {
+	// Encoding is the content coding of compressed bodies: "gzip" (the default) or "deflate".
+	Encoding string
+
+	// Level is the compression level, e.g. gzip.BestSpeed. Defaults to the default compression level.
+	Level int
+
+	// MinSize is the minimal body size in bytes to compress. Defaults to 1024; a negative value compresses any body.
+	MinSize int64
+}
+
+// CompressRequest compresses request bodies of at least MinSize bytes and sets "Content-Encoding".
+// Bodies that already have a "Content-Encoding" are sent as is. The body is read into memory,
+// so the compressed request has a known length and can be retried (see http.Request.GetBody).
+// The server must accept compressed requests, e.g. with httpserver.Decompress.
+// It panics if the encoding or the level is invalid.
+func CompressRequest(opts *CompressOptions) func(http.RoundTripper) http.RoundTripper {
+	if opts == nil {
+		opts = &CompressOptions{}
+	}
+
+	encoding := opts.Encoding
+	if encoding == "" {
+		encoding = "gzip"
+	}
+
+	level := opts.Level
+	if level == 0 {
+		level = flate.DefaultCompression
+	}
+
+	minSize := opts.MinSize
+	if minSize == 0 {
+		minSize = 1024
+	}
+
+	newWriter := func(w io.Writer) (io.WriteCloser, error) {
+		switch encoding {
+		case "gzip":
+			return gzip.NewWriterLevel(w, level)
+		case "deflate":
+			return zlib.NewWriterLevel(w, level)
+		default:
+			return nil, fmt.Errorf("unsupported request content encoding %q", encoding)
+		}
+	}
+
+	if _, err := newWriter(io.Discard); err != nil {
+		panic("httpclient: " + err.Error())
+	}
+
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripper(func(r *http.Request) (*http.Response, error) {
+			if r.Body == nil || r.Body == http.NoBody || r.Header.Get("Content-Encoding") != "" ||
+				(r.ContentLength > 0 && r.ContentLength < minSize) {
+				return next.RoundTrip(r)
+			}
+
+			p, err := io.ReadAll(r.Body)
+			if closeErr := r.Body.Close(); err == nil {
+				err = closeErr
+			}
+			if err != nil {
+				return nil, err
+			}
+
+			r = r.Clone(r.Context())
+
+			if int64(len(p)) < minSize {
+				r.Body, r.ContentLength = io.NopCloser(bytes.NewReader(p)), int64(len(p))
+				r.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(p)), nil }
+				return next.RoundTrip(r)
+			}
+
+			buf := new(bytes.Buffer)
+
+			zw, err := newWriter(buf)
+			if err != nil {
+				return nil, err
+			}
+			if _, err = zw.Write(p); err != nil {
+				return nil, err
+			}
+			if err = zw.Close(); err != nil {
+				return nil, err
+			}
+
+			compressed := buf.Bytes()
+
+			r.Body, r.ContentLength = io.NopCloser(bytes.NewReader(compressed)), int64(len(compressed))
+			r.GetBody = func() (io.ReadCloser, error) { return io.NopCloser(bytes.NewReader(compressed)), nil }
+			r.Header.Set("Content-Encoding", encoding)
+
+			return next.RoundTrip(r)
+		})
+	}
+}
@@ -0,0 +1,209 @@
+package httpclient
+
+import (
+	"context"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+	"time"
+)
+
+// HedgeOptions represents configuration for the HedgeWithOptions middleware.
+type HedgeOptions struct {
+	// Delay is how long an attempt may go without a response before another one is started.
+	// Zero or a negative value disables hedging.
+	Delay time.Duration
+
+	// Attempts is the maximum number of attempts of a request. Values below 2 disable hedging.
+	Attempts int
+
+	// Methods lists the methods of the requests that are hedged. Defaults to GET, HEAD and OPTIONS.
+	// Add idempotent methods with side effects, such as PUT and DELETE, only if the server tolerates
+	// the same write arriving several times, possibly concurrently.
+	Methods []string
+}
+
+// Hedge sends up to attempts identical GET, HEAD and OPTIONS requests to cut the tail latency.
+// See HedgeWithOptions.
+func Hedge(delay time.Duration, attempts int) func(http.RoundTripper) http.RoundTripper {
+	return HedgeWithOptions(&HedgeOptions{Delay: delay, Attempts: attempts})
+}
+
+// HedgeWithOptions returns a Hedge configured by opts.
+// When an attempt has not responded within the delay, or has failed, another one is started.
+// The first successful response (no error and a status code below 500) is returned and the other
+// attempts are canceled. If all attempts fail, the failure of the last one is returned.
+//
+// A request with a body must have GetBody set, as requests made by http.NewRequest usually do.
+// A request that has been hedged logs the winning attempt at the info level, with the trace ID of its context.
+// The attempts share the trace of the request, so place Hedge before Tracer in RoundTripperSequencer
+// to have a client span per attempt.
+func HedgeWithOptions(opts *HedgeOptions) func(http.RoundTripper) http.RoundTripper {
+	if opts == nil {
+		opts = &HedgeOptions{}
+	}
+
+	delay, attempts := opts.Delay, opts.Attempts
+
+	methods := opts.Methods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodHead, http.MethodOptions}
+	}
+	methods = slices.Clone(methods)
+
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripper(func(r *http.Request) (*http.Response, error) {
+			if delay <= 0 || attempts < 2 || !hedgeable(r, methods) {
+				return next.RoundTrip(r)
+			}
+			return newHedgedRequest(next, r, attempts).do(delay)
+		})
+	}
+}
+
+// hedgeable reports whether the request may be sent several times.
+func hedgeable(r *http.Request, methods []string) bool {
+	if !slices.Contains(methods, r.Method) {
+		return false
+	}
+	return r.Body == nil || r.Body == http.NoBody || r.GetBody != nil
+}
+
+type hedgeResult struct {
+	attempt  int
+	response *http.Response
+	err      error
+}
+
+type hedgedRequest struct {
+	next     http.RoundTripper
+	r        *http.Request
+	attempts int
+	cancels  []context.CancelFunc
+	results  chan hedgeResult
+	pending  int
+}
+
+func newHedgedRequest(next http.RoundTripper, r *http.Request, attempts int) *hedgedRequest {
+	return &hedgedRequest{next: next, r: r, attempts: attempts, results: make(chan hedgeResult, attempts)}
+}
+
+// start sends a new attempt, unless all the attempts have been sent or the request is canceled.
+func (h *hedgedRequest) start() bool {
+	if len(h.cancels) == h.attempts || h.r.Context().Err() != nil {
+		return false
+	}
+
+	attempt := len(h.cancels)
+
+	ctx, cancel := context.WithCancel(h.r.Context())
+	h.cancels = append(h.cancels, cancel)
+	h.pending++
+
+	r := h.r.Clone(ctx)
+	if attempt > 0 && h.r.Body != nil && h.r.Body != http.NoBody {
+		body, err := h.r.GetBody()
+		if err != nil {
+			h.results <- hedgeResult{attempt: attempt, err: err}
+			return true
+		}
+		r.Body = body
+	}
+
+	go func() {
+		response, err := h.next.RoundTrip(r)
+		h.results <- hedgeResult{attempt: attempt, response: response, err: err}
+	}()
+
+	return true
+}
+
+func (h *hedgedRequest) do(delay time.Duration) (*http.Response, error) {
+	h.start()
+
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+
+	var last hedgeResult
+
+	for {
+		select {
+		case <-timer.C:
+			if h.start() {
+				timer.Reset(delay)
+			}
+
+		case res := <-h.results:
+			h.pending--
+
+			if res.err == nil && res.response.StatusCode < http.StatusInternalServerError {
+				closeResponse(last)
+				h.finish(res)
+				if len(h.cancels) > 1 {
+					slog.InfoContext(h.r.Context(), "hedged request",
+						slog.String("method", h.r.Method),
+						slog.String("url", h.r.URL.String()),
+						slog.Int("attempt", res.attempt+1),
+						slog.Int("attempts", len(h.cancels)),
+					)
+				}
+				return res.response, nil
+			}
+
+			closeResponse(last)
+			last = res
+
+			// A failed attempt is replaced at once.
+			if h.start() {
+				timer.Reset(delay)
+			} else if h.pending == 0 {
+				h.finish(last)
+				return last.response, last.err
+			}
+		}
+	}
+}
+
+// finish cancels all the attempts other than the one of the result, closes their responses in the background,
+// and makes the body of the result cancel its attempt when it is closed.
+func (h *hedgedRequest) finish(res hedgeResult) {
+	for i, cancel := range h.cancels {
+		if i != res.attempt {
+			cancel()
+		}
+	}
+
+	if pending := h.pending; pending > 0 {
+		go func() {
+			for range pending {
+				closeResponse(<-h.results)
+			}
+		}()
+	}
+
+	if res.response == nil {
+		h.cancels[res.attempt]()
+		return
+	}
+	res.response.Body = wrapBody(res.response.Body, &cancelBody{ReadCloser: res.response.Body, cancel: h.cancels[res.attempt]})
+}
+
+func closeResponse(res hedgeResult) {
+	if res.response != nil {
+		_, _ = io.Copy(io.Discard, io.LimitReader(res.response.Body, 4<<10))
+		_ = res.response.Body.Close()
+	}
+}
+
+// cancelBody cancels the context of its request when it is closed.
+type cancelBody struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (b *cancelBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.cancel()
+	return err
+}
@@ -0,0 +1,132 @@
+package httpclient
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/easysy/proton/metrics"
+)
+
+// MetricsOptions represents configuration for the Metrics middleware.
+type MetricsOptions struct {
+	// Registry receives the metrics. Defaults to metrics.DefaultRegistry.
+	Registry *metrics.Registry
+
+	// Namespace is an optional prefix of the metric names, e.g. "myapp" for "myapp_http_client_requests_total".
+	Namespace string
+
+	// Buckets are the bounds of the request duration histogram in seconds. Defaults to metrics.DefBuckets.
+	Buckets []float64
+
+	// SizeBuckets are the bounds of the response size histogram in bytes. Defaults to metrics.SizeBuckets.
+	SizeBuckets []float64
+}
+
+type clientMetrics struct {
+	requests *metrics.Counter
+	duration *metrics.Histogram
+	size     *metrics.Histogram
+	inFlight *metrics.Gauge
+}
+
+func newClientMetrics(opts *MetricsOptions) *clientMetrics {
+	if opts == nil {
+		opts = &MetricsOptions{}
+	}
+
+	reg := opts.Registry
+	if reg == nil {
+		reg = metrics.DefaultRegistry
+	}
+
+	sizeBuckets := opts.SizeBuckets
+	if len(sizeBuckets) == 0 {
+		sizeBuckets = metrics.SizeBuckets
+	}
+
+	name := func(s string) string {
+		if opts.Namespace == "" {
+			return s
+		}
+		return opts.Namespace + "_" + s
+	}
+
+	return &clientMetrics{
+		requests: reg.NewCounter(name("http_client_requests_total"),
+			"Total number of HTTP requests sent.", "method", "host", "status"),
+		duration: reg.NewHistogram(name("http_client_request_duration_seconds"),
+			"Time until HTTP response headers are received in seconds.", opts.Buckets, "method", "host", "status"),
+		size: reg.NewHistogram(name("http_client_response_size_bytes"),
+			"Size of HTTP response bodies read in bytes.", sizeBuckets, "method", "host", "status"),
+		inFlight: reg.NewGauge(name("http_client_requests_in_flight"),
+			"Number of HTTP requests waiting for response headers.", "host"),
+	}
+}
+
+// Metrics collects request counters, latency and response size histograms, and an in-flight gauge
+// labelled by method, host and status code. Requests failed without a response have the status "error".
+// The response size is observed when the body is read to the end or closed.
+// Serve the metrics with metrics.Handler.
+func Metrics(opts *MetricsOptions) func(http.RoundTripper) http.RoundTripper {
+	m := newClientMetrics(opts)
+
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripper(func(r *http.Request) (*http.Response, error) {
+			method, host := metrics.Method(r.Method), r.URL.Host
+
+			m.inFlight.Inc(host)
+			start := time.Now()
+
+			response, err := next.RoundTrip(r)
+
+			m.inFlight.Dec(host)
+			duration := time.Since(start).Seconds()
+
+			if err != nil {
+				m.requests.Inc(method, host, "error")
+				m.duration.Observe(duration, method, host, "error")
+				return nil, err
+			}
+
+			status := strconv.Itoa(response.StatusCode)
+
+			m.requests.Inc(method, host, status)
+			m.duration.Observe(duration, method, host, status)
+
+			observe := func(n int64) { m.size.Observe(float64(n), method, host, status) }
+
+			if response.Body == nil || response.Body == http.NoBody {
+				observe(0)
+			} else {
+				response.Body = wrapBody(response.Body, &countingBody{ReadCloser: response.Body, observe: observe})
+			}
+
+			return response, nil
+		})
+	}
+}
+
+// countingBody counts the bytes read from the body and reports them once, on EOF or Close.
+type countingBody struct {
+	io.ReadCloser
+	n       int64
+	once    sync.Once
+	observe func(n int64)
+}
+
+func (b *countingBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	b.n += int64(n)
+	if err == io.EOF {
+		b.once.Do(func() { b.observe(b.n) })
+	}
+	return n, err
+}
+
+func (b *countingBody) Close() error {
+	b.once.Do(func() { b.observe(b.n) })
+	return b.ReadCloser.Close()
+}
@@ -0,0 +1,151 @@
+package httpclient
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ClientOption configures a Client created by New.
+type ClientOption func(d *defaults)
+
+// RequestOption modifies a request of Client.Request or Client.SendFile before it is sent.
+// Any func(*http.Request) may be passed as a RequestOption.
+type RequestOption func(r *http.Request)
+
+// defaults holds the configuration applied to every request of a Client.
+type defaults struct {
+	baseURL   *url.URL
+	header    http.Header
+	userAgent string
+	query     url.Values
+}
+
+// WithBaseURL sets the URL relative request URLs are joined to: the path of a request URL is appended
+// to the path of the base URL, e.g. "users/1" and "/users/1" both give "http://api/v1/users/1"
+// with the base URL "http://api/v1". Absolute request URLs are used as is, and scheme relative ones,
+// e.g. "//cdn/file", get the scheme of the base URL.
+// It panics if base is not an absolute URL.
+func WithBaseURL(base string) ClientOption {
+	u, err := url.Parse(base)
+	if err != nil || !u.IsAbs() {
+		panic("httpclient: invalid base URL " + base)
+	}
+	return func(d *defaults) {
+		d.baseURL = u
+	}
+}
+
+// WithHeader adds the header value to every request. The default values are set before
+// the RequestOptions are applied: a RequestOption using Header.Set, e.g. Header, replaces them,
+// and a RequestOption using Header.Add appends to them.
+func WithHeader(key, value string) ClientOption {
+	return func(d *defaults) {
+		if d.header == nil {
+			d.header = make(http.Header)
+		}
+		d.header.Add(key, value)
+	}
+}
+
+// WithUserAgent sets the User-Agent header of every request, unless the request sets its own.
+func WithUserAgent(userAgent string) ClientOption {
+	return func(d *defaults) {
+		d.userAgent = userAgent
+	}
+}
+
+// WithQuery adds the query parameter to every request, unless the request URL has the parameter already.
+func WithQuery(key, value string) ClientOption {
+	return func(d *defaults) {
+		if d.query == nil {
+			d.query = make(url.Values)
+		}
+		d.query.Add(key, value)
+	}
+}
+
+// Header sets the header of the request.
+func Header(key, value string) RequestOption {
+	return func(r *http.Request) {
+		r.Header.Set(key, value)
+	}
+}
+
+// Query adds the query parameter to the request URL.
+func Query(key, value string) RequestOption {
+	return func(r *http.Request) {
+		q := r.URL.Query()
+		q.Add(key, value)
+		r.URL.RawQuery = q.Encode()
+	}
+}
+
+// newRequest returns a request to the URL joined to the base URL, with the default headers and query parameters.
+func (d *defaults) newRequest(ctx context.Context, method, rawURL string, body io.Reader) (*http.Request, error) {
+	if d.baseURL != nil {
+		u, err := url.Parse(rawURL)
+		if err != nil {
+			return nil, err
+		}
+		switch {
+		case u.Host != "" && !u.IsAbs():
+			rawURL = d.baseURL.ResolveReference(u).String()
+		case !u.IsAbs():
+			rawURL = d.join(u).String()
+		}
+	}
+
+	request, err := http.NewRequestWithContext(ctx, method, rawURL, body)
+	if err != nil {
+		return nil, err
+	}
+
+	for key, values := range d.header {
+		if _, ok := request.Header[key]; !ok {
+			request.Header[key] = append([]string(nil), values...)
+		}
+	}
+
+	if d.userAgent != "" {
+		request.Header.Set("User-Agent", d.userAgent)
+	}
+
+	if len(d.query) > 0 {
+		q := request.URL.Query()
+		for key, values := range d.query {
+			if !q.Has(key) {
+				q[key] = append([]string(nil), values...)
+			}
+		}
+		request.URL.RawQuery = q.Encode()
+	}
+
+	return request, nil
+}
+
+// join returns the relative URL joined to the base URL.
+func (d *defaults) join(u *url.URL) *url.URL {
+	joined := d.baseURL.JoinPath(strings.TrimPrefix(u.EscapedPath(), "/"))
+
+	switch {
+	case d.baseURL.RawQuery == "":
+		joined.RawQuery = u.RawQuery
+	case u.RawQuery != "":
+		joined.RawQuery = d.baseURL.RawQuery + "&" + u.RawQuery
+	}
+	joined.Fragment, joined.RawFragment = u.Fragment, u.RawFragment
+
+	return joined
+}
+
+// applyOptions applies the request options, skipping nil ones.
+func applyOptions(r *http.Request, opts []RequestOption) {
+	for _, opt := range opts {
+		if opt != nil {
+			opt(r)
+		}
+	}
+}
@@ -1,22 +1,16 @@
 package httpclient
 
 import (
-	"context"
+	"errors"
+	"io"
 	"log/slog"
 	"net/http"
 	"runtime/debug"
 	"time"
 
 	"github.com/easysy/proton/log"
-	"github.com/easysy/proton/sgen"
 )
 
-func init() {
-	id.Configure("", sgen.UpLetters.Append(sgen.LowLetters, sgen.Nums), 12)
-}
-
-var id sgen.RandomString
-
 // The RoundTripper type is an adapter to allow the use of ordinary functions as HTTP round trippers.
 // If f is a function with the appropriate signature, Func(f) is a RoundTripper that calls f.
 type RoundTripper func(*http.Request) (*http.Response, error)
@@ -34,30 +28,102 @@ func RoundTripperSequencer(baseRoundTripper http.RoundTripper, rts ...func(http.
 	return baseRoundTripper
 }
 
+// TracerOptions represents configuration for the TracerWithOptions middleware.
+type TracerOptions struct {
+	// RequestIDHeader is a legacy request ID header, e.g. "X-Request-ID", set to the trace ID
+	// in addition to the W3C headers. Empty disables it.
+	RequestIDHeader string
+}
+
+var tracer = TracerWithOptions(&TracerOptions{RequestIDHeader: "X-Request-ID"})
+
 // Tracer adds trace ID to the request context.
+// It reuses the trace ID of the context, if any, or starts a new trace, and propagates it to the server
+// in the W3C "traceparent" (and "tracestate") and "X-Request-ID" headers.
+// Headers already set on the request are not overwritten.
+// The request is sent within a client span (see log.StartSpan), which becomes the parent of the server span.
 func Tracer(next http.RoundTripper) http.RoundTripper {
-	return RoundTripper(func(r *http.Request) (*http.Response, error) {
-		ctx := context.WithValue(r.Context(), log.TraceCtxKey, id.Generate())
-		return next.RoundTrip(r.Clone(ctx))
-	})
+	return tracer(next)
+}
+
+// TracerWithOptions returns a Tracer configured by opts.
+// See Tracer and TracerOptions.
+func TracerWithOptions(opts *TracerOptions) func(http.RoundTripper) http.RoundTripper {
+	if opts == nil {
+		opts = &TracerOptions{}
+	}
+
+	header := opts.RequestIDHeader
+
+	return func(next http.RoundTripper) http.RoundTripper {
+		return RoundTripper(func(r *http.Request) (*http.Response, error) {
+			ctx, span := log.StartSpan(r.Context(), r.Method, log.SpanKindClient,
+				slog.String("http.request.method", r.Method),
+				slog.String("server.address", r.URL.Host),
+				slog.String("url.path", r.URL.Path),
+			)
+			defer span.End()
+
+			r = r.Clone(ctx)
+
+			if log.IsTraceID(span.TraceID) && r.Header.Get(log.TraceParentHeader) == "" {
+				parent := log.TraceParent{TraceID: span.TraceID, SpanID: span.SpanID, Flags: log.TraceFlagSampled}
+				if p, ok := ctx.Value(log.TraceParentCtxKey).(log.TraceParent); ok && p.TraceID == span.TraceID {
+					parent.Flags = p.Flags
+				}
+				r.Header.Set(log.TraceParentHeader, parent.String())
+
+				if state, _ := ctx.Value(log.TraceStateCtxKey).(string); state != "" {
+					r.Header.Set(log.TraceStateHeader, state)
+				}
+			}
+
+			if header != "" && r.Header.Get(header) == "" {
+				r.Header.Set(header, span.TraceID)
+			}
+
+			response, err := next.RoundTrip(r)
+			if err != nil {
+				span.SetError(err)
+				return nil, err
+			}
+
+			span.SetAttributes(slog.Int("http.response.status_code", response.StatusCode))
+			if response.StatusCode >= http.StatusBadRequest {
+				span.SetError(errors.New(response.Status))
+			}
+
+			return response, nil
+		})
+	}
 }
 
 // Timer measures the time taken by http.RoundTripper.
+// The status of a response handled by the Cache middleware is logged as "cache" (see CacheStatus).
 func Timer(level slog.Level) func(http.RoundTripper) http.RoundTripper {
 	return func(next http.RoundTripper) http.RoundTripper {
 		return RoundTripper(func(r *http.Request) (*http.Response, error) {
 			ctx := r.Context()
-			if slog.Default().Enabled(ctx, level) {
-				defer func(start time.Time) {
-					slog.Log(ctx, level, "finished",
-						slog.Group("request",
-							slog.String("method", r.Method),
-							slog.String("url", r.URL.String()),
-						),
-						slog.String("duration", time.Since(start).String()))
-				}(time.Now())
+			if !slog.Default().Enabled(ctx, level) {
+				return next.RoundTrip(r)
 			}
-			return next.RoundTrip(r)
+
+			start := time.Now()
+			response, err := next.RoundTrip(r)
+
+			attrs := []slog.Attr{
+				slog.Group("request",
+					slog.String("method", r.Method),
+					slog.String("url", r.URL.String()),
+				),
+				slog.String("duration", time.Since(start).String()),
+			}
+			if status := CacheStatus(response); status != "" {
+				attrs = append(attrs, slog.String("cache", status))
+			}
+			slog.LogAttrs(ctx, level, "finished", attrs...)
+
+			return response, err
 		})
 	}
 }
@@ -77,6 +143,7 @@ func PanicCatcher(next http.RoundTripper) http.RoundTripper {
 }
 
 // DumpHttp dumps the HTTP request and response, and prints out with logFunc.
+// The status of a response handled by the Cache middleware is logged as "cache" (see CacheStatus).
 func DumpHttp(level slog.Level, body bool) func(http.RoundTripper) http.RoundTripper {
 	return func(next http.RoundTripper) http.RoundTripper {
 		return RoundTripper(func(r *http.Request) (*http.Response, error) {
@@ -89,7 +156,11 @@ func DumpHttp(level slog.Level, body bool) func(http.RoundTripper) http.RoundTri
 					return nil, err
 				}
 
-				log.DumpHttpResponse(ctx, response, level, body)
+				var attrs []slog.Attr
+				if status := CacheStatus(response); status != "" {
+					attrs = append(attrs, slog.String("cache", status))
+				}
+				log.DumpHttpResponse(ctx, response, level, body, attrs...)
 
 				return response, nil
 			}
@@ -97,3 +168,16 @@ func DumpHttp(level slog.Level, body bool) func(http.RoundTripper) http.RoundTri
 		})
 	}
 }
+
+// wrapBody returns the wrapper of a response body with the Write method of the body, if it has one:
+// the body of a 101 Switching Protocols response is an io.ReadWriteCloser (see http.Response),
+// which must stay writable through the middlewares.
+func wrapBody(body, wrapper io.ReadCloser) io.ReadCloser {
+	if w, ok := body.(io.Writer); ok {
+		return struct {
+			io.ReadCloser
+			io.Writer
+		}{wrapper, w}
+	}
+	return wrapper
+}
@@ -0,0 +1,376 @@
+package httpserver
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/easysy/proton/log"
+)
+
+// AccessLogFormat selects how the AccessLog middleware emits entries.
+type AccessLogFormat int
+
+const (
+	// AccessLogSlog emits every entry as a slog record at AccessLogOptions.Level.
+	AccessLogSlog AccessLogFormat = iota
+	// AccessLogCombined writes the Apache Combined Log Format to AccessLogOptions.Output.
+	AccessLogCombined
+	// AccessLogJSON writes one JSON object per line to AccessLogOptions.Output.
+	AccessLogJSON
+)
+
+// AccessLogField is a set of fields included in AccessLogSlog and AccessLogJSON entries.
+type AccessLogField uint
+
+const (
+	FieldMethod AccessLogField = 1 << iota
+	FieldURL
+	FieldProto
+	FieldStatus
+	FieldSize
+	FieldRemoteIP
+	FieldUserAgent
+	FieldReferer
+	FieldTraceID
+	FieldDuration
+	FieldRoute
+
+	// FieldAll includes every field; it is used when AccessLogOptions.Fields is zero.
+	FieldAll = FieldMethod | FieldURL | FieldProto | FieldStatus | FieldSize |
+		FieldRemoteIP | FieldUserAgent | FieldReferer | FieldTraceID | FieldDuration | FieldRoute
+)
+
+// AccessLogOptions represents configuration for the AccessLog middleware.
+type AccessLogOptions struct {
+	// Format selects the output format. AccessLogSlog is used by default.
+	Format AccessLogFormat
+
+	// Level is the slog level of AccessLogSlog entries.
+	Level slog.Level
+
+	// Fields selects the fields of AccessLogSlog and AccessLogJSON entries.
+	// Zero means FieldAll. The Combined format always has its fixed set of fields.
+	// When the default logger uses log.TraceHandler, FieldTraceID may be omitted to avoid duplicates.
+	Fields AccessLogField
+
+	// Output receives AccessLogCombined and AccessLogJSON lines. Defaults to os.Stdout.
+	// Writes are serialized by the middleware.
+	Output io.Writer
+
+	// SampleRate is the fraction of requests, in the range (0, 1), that are logged.
+	// Zero or values greater than or equal to one log every request.
+	// Responses with a 5xx status are always logged.
+	SampleRate float64
+
+	// SkipPaths lists URL paths that are never logged, such as health checks.
+	// A path ending with a slash matches every path under it.
+	SkipPaths []string
+
+	// RemoteIPHeader is an optional request header, e.g. "X-Forwarded-For", containing the client IP
+	// set by a trusted proxy. The first address of the header is used if it is a valid IP;
+	// RemoteAddr is the fallback.
+	RemoteIPHeader string
+}
+
+type accessLog struct {
+	format   AccessLogFormat
+	level    slog.Level
+	fields   AccessLogField
+	rate     float64
+	exact    map[string]struct{}
+	prefixes []string
+	ipHeader string
+
+	mu  sync.Mutex
+	out io.Writer
+}
+
+func newAccessLog(opts *AccessLogOptions) *accessLog {
+	if opts == nil {
+		opts = &AccessLogOptions{}
+	}
+
+	a := &accessLog{
+		format:   opts.Format,
+		level:    opts.Level,
+		fields:   opts.Fields,
+		rate:     opts.SampleRate,
+		exact:    make(map[string]struct{}),
+		ipHeader: opts.RemoteIPHeader,
+		out:      opts.Output,
+	}
+
+	if a.fields == 0 {
+		a.fields = FieldAll
+	}
+
+	if a.out == nil {
+		a.out = os.Stdout
+	}
+
+	for _, path := range opts.SkipPaths {
+		if strings.HasSuffix(path, "/") {
+			a.prefixes = append(a.prefixes, path)
+		} else {
+			a.exact[path] = struct{}{}
+		}
+	}
+
+	return a
+}
+
+func (a *accessLog) skip(path string) bool {
+	if _, ok := a.exact[path]; ok {
+		return true
+	}
+	for _, prefix := range a.prefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+func (a *accessLog) sampled(status int) bool {
+	if a.rate <= 0 || a.rate >= 1 || status >= http.StatusInternalServerError {
+		return true
+	}
+	return rand.Float64() < a.rate
+}
+
+// accessEntry holds the data about one served request.
+type accessEntry struct {
+	start     time.Time
+	duration  time.Duration
+	method    string
+	url       string
+	proto     string
+	status    int
+	size      int64
+	remoteIP  string
+	user      string
+	userAgent string
+	referer   string
+	traceID   string
+	route     string
+	hijacked  bool
+}
+
+func (a *accessLog) entry(r *http.Request, w *responseWriter, status int, start time.Time, route *routeHolder) *accessEntry {
+	e := &accessEntry{
+		start:     start,
+		duration:  time.Since(start),
+		method:    r.Method,
+		url:       r.RequestURI,
+		proto:     r.Proto,
+		status:    status,
+		size:      w.Size(),
+		remoteIP:  remoteIP(r, a.ipHeader),
+		userAgent: r.UserAgent(),
+		referer:   r.Referer(),
+		hijacked:  w.hijacked,
+	}
+
+	e.traceID = log.TraceID(r.Context())
+	if matched, ok := route.get(); ok {
+		e.route = matched.String()
+	}
+	e.user, _, _ = r.BasicAuth()
+
+	return e
+}
+
+// remoteIP returns the client IP taken from the header, if set to a valid IP, or from RemoteAddr.
+func remoteIP(r *http.Request, header string) string {
+	if header != "" {
+		first, _, _ := strings.Cut(r.Header.Get(header), ",")
+		if ip := net.ParseIP(strings.TrimSpace(first)); ip != nil {
+			return ip.String()
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+	return host
+}
+
+func (e *accessEntry) attrs(fields AccessLogField) []slog.Attr {
+	attrs := make([]slog.Attr, 0, 11)
+
+	add := func(field AccessLogField, attr slog.Attr) {
+		if fields&field != 0 {
+			attrs = append(attrs, attr)
+		}
+	}
+
+	add(FieldMethod, slog.String("method", e.method))
+	add(FieldURL, slog.String("url", e.url))
+	add(FieldProto, slog.String("proto", e.proto))
+	add(FieldStatus, slog.Int("status", e.status))
+	if e.hijacked {
+		add(FieldStatus, slog.Bool("hijacked", true))
+	}
+	add(FieldSize, slog.Int64("size", e.size))
+	add(FieldRemoteIP, slog.String("remote_ip", e.remoteIP))
+	add(FieldUserAgent, slog.String("user_agent", e.userAgent))
+	add(FieldReferer, slog.String("referer", e.referer))
+	if e.traceID != "" {
+		add(FieldTraceID, slog.String("trace_id", e.traceID))
+	}
+	add(FieldDuration, slog.String("duration", e.duration.String()))
+	if e.route != "" {
+		add(FieldRoute, slog.String("route", e.route))
+	}
+
+	return attrs
+}
+
+// combined formats the entry as the Apache Combined Log Format:
+//
+//	%h %l %u %t "%r" %>s %b "%{Referer}i" "%{User-agent}i"
+//
+// Fields sent by the client are escaped as Go string literals, so that they cannot forge log lines or fields;
+// spaces are escaped too in the unquoted %h and %u fields.
+func (e *accessEntry) combined(buf *bytes.Buffer) {
+	dash := func(s string) string {
+		if s == "" {
+			return "-"
+		}
+		return escape(s)
+	}
+
+	// token escapes a field written without quotes, so that it stays a single field.
+	token := func(s string) string {
+		return strings.ReplaceAll(dash(s), " ", `\x20`)
+	}
+
+	buf.WriteString(token(e.remoteIP))
+	buf.WriteString(" - ")
+	buf.WriteString(token(e.user))
+	buf.WriteString(" [")
+	buf.WriteString(e.start.Format("02/Jan/2006:15:04:05 -0700"))
+	buf.WriteString("] \"")
+	buf.WriteString(escape(e.method))
+	buf.WriteByte(' ')
+	buf.WriteString(escape(e.url))
+	buf.WriteByte(' ')
+	buf.WriteString(escape(e.proto))
+	buf.WriteString("\" ")
+	buf.WriteString(strconv.Itoa(e.status))
+	buf.WriteByte(' ')
+	if e.size == 0 {
+		buf.WriteByte('-')
+	} else {
+		buf.WriteString(strconv.FormatInt(e.size, 10))
+	}
+	buf.WriteString(" \"")
+	buf.WriteString(dash(e.referer))
+	buf.WriteString("\" \"")
+	buf.WriteString(dash(e.userAgent))
+	buf.WriteString("\"\n")
+}
+
+// escape escapes backslashes, double quotes, control characters and invalid UTF-8 in s as strconv.Quote does.
+func escape(s string) string {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; c < 0x20 || c >= 0x7f || c == '"' || c == '\\' {
+			q := strconv.Quote(s)
+			return q[1 : len(q)-1]
+		}
+	}
+	return s
+}
+
+// json formats the entry as a single line JSON object with the fields in a stable order.
+func (e *accessEntry) json(buf *bytes.Buffer, fields AccessLogField) {
+	buf.WriteString(`{"time":`)
+	b, _ := json.Marshal(e.start.Format(time.RFC3339Nano))
+	buf.Write(b)
+
+	for _, attr := range e.attrs(fields) {
+		buf.WriteByte(',')
+		b, _ = json.Marshal(attr.Key)
+		buf.Write(b)
+		buf.WriteByte(':')
+		b, _ = json.Marshal(attr.Value.Any())
+		buf.Write(b)
+	}
+
+	buf.WriteString("}\n")
+}
+
+func (a *accessLog) emit(ctx context.Context, e *accessEntry) {
+	if a.format == AccessLogSlog {
+		slog.LogAttrs(ctx, a.level, "access", e.attrs(a.fields)...)
+		return
+	}
+
+	buf := new(bytes.Buffer)
+	if a.format == AccessLogCombined {
+		e.combined(buf)
+	} else {
+		e.json(buf, a.fields)
+	}
+
+	a.mu.Lock()
+	_, err := buf.WriteTo(a.out)
+	a.mu.Unlock()
+
+	if err != nil {
+		slog.ErrorContext(ctx, "write access log", "error", err)
+	}
+}
+
+// AccessLog logs every served request with its status code, response size, remote IP, user agent,
+// trace ID, duration and matched route (see Route). See AccessLogOptions for the formats, sampling and path exclusions.
+func AccessLog(opts *AccessLogOptions) func(http.Handler) http.Handler {
+	a := newAccessLog(opts)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+			if a.skip(r.URL.Path) || (a.format == AccessLogSlog && !slog.Default().Enabled(ctx, a.level)) {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			rw := newResponseWriter(w)
+			r, route := withRoute(r)
+
+			defer func(start time.Time) {
+				route.capture(r)
+
+				status := rw.Status()
+
+				// A panic is logged as the 500 response it results in, and then passed on to PanicCatcher or the server.
+				p := recover()
+				if p != nil && !rw.hijacked {
+					status = http.StatusInternalServerError
+				}
+
+				if a.sampled(status) {
+					a.emit(ctx, a.entry(r, rw, status, start, route))
+				}
+
+				if p != nil {
+					panic(p)
+				}
+			}(time.Now())
+
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package httpserver_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"testing"
+
+	"github.com/easysy/proton/httpserver"
+	"github.com/easysy/proton/log"
+)
+
+func TestAccessLog(t *testing.T) {
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		_, _ = w.Write([]byte("hello"))
+	})
+
+	var tests = []struct {
+		name   string
+		opts   httpserver.AccessLogOptions
+		path   string
+		expect func(t *testing.T, out string)
+	}{
+		{
+			name: "combined format",
+			opts: httpserver.AccessLogOptions{Format: httpserver.AccessLogCombined},
+			path: "/users?id=1",
+			expect: func(t *testing.T, out string) {
+				re := regexp.MustCompile(`^192\.0\.2\.1 - - \[.+] "GET /users\?id=1 HTTP/1\.1" 201 5 "-" "test-agent"\n$`)
+				equal(t, true, re.MatchString(out))
+			},
+		},
+		{
+			name: "json format with selected fields",
+			opts: httpserver.AccessLogOptions{
+				Format: httpserver.AccessLogJSON,
+				Fields: httpserver.FieldStatus | httpserver.FieldSize | httpserver.FieldTraceID,
+			},
+			path: "/users",
+			expect: func(t *testing.T, out string) {
+				entry := make(map[string]any)
+				equal(t, nil, json.Unmarshal([]byte(out), &entry))
+				equal(t, 4, len(entry))
+				equal(t, float64(http.StatusCreated), entry["status"])
+				equal(t, float64(5), entry["size"])
+				equal(t, "trace", entry["trace_id"])
+			},
+		},
+		{
+			name: "skipped path",
+			opts: httpserver.AccessLogOptions{Format: httpserver.AccessLogJSON, SkipPaths: []string{"/health/"}},
+			path: "/health/live",
+			expect: func(t *testing.T, out string) {
+				equal(t, "", out)
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out := new(bytes.Buffer)
+			test.opts.Output = out
+
+			r := httptest.NewRequest(http.MethodGet, test.path, nil)
+			r.Header.Set("User-Agent", "test-agent")
+			r = r.WithContext(log.WithTraceID(r.Context(), "trace"))
+
+			w := httptest.NewRecorder()
+			httpserver.AccessLog(&test.opts)(handler).ServeHTTP(w, r)
+
+			equal(t, http.StatusCreated, w.Code)
+			equal(t, "hello", w.Body.String())
+
+			test.expect(t, out.String())
+		})
+	}
+}
+
+func TestAccessLog_CombinedEscaping(t *testing.T) {
+	out := new(bytes.Buffer)
+
+	handler := httpserver.AccessLog(&httpserver.AccessLogOptions{
+		Format:         httpserver.AccessLogCombined,
+		Output:         out,
+		RemoteIPHeader: "X-Forwarded-For",
+	})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.SetBasicAuth("eve\" 200 -\n10.0.0.1 - admin", "secret")
+	r.Header.Set("X-Forwarded-For", "10.0.0.2 - admin, 10.0.0.3")
+	r.Header.Set("User-Agent", `agent\"`)
+	r.Header.Set("Referer", "http://example.com/\x1b[31m")
+
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	// %h %l %u [%t] "%r" %>s %b "%{Referer}i" "%{User-agent}i"
+	quoted := `"((?:[^"\\]|\\.)*)"`
+	re := regexp.MustCompile(`^(\S+) (\S+) (\S+) \[([^]]+)] ` + quoted + ` (\d{3}) (\S+) ` + quoted + ` ` + quoted + "\n$")
+
+	fields := re.FindStringSubmatch(out.String())
+	equal(t, 10, len(fields)) // the whole line and its 9 fields
+
+	equal(t, "192.0.2.1", fields[1]) // the forged header is not a valid IP
+	equal(t, `eve\"\x20200\x20-\n10.0.0.1\x20-\x20admin`, fields[3])
+	equal(t, "GET / HTTP/1.1", fields[5])
+	equal(t, "200", fields[6])
+	equal(t, `http://example.com/\x1b[31m`, fields[8])
+	equal(t, `agent\\\"`, fields[9])
+}
+
+func TestAccessLog_Panic(t *testing.T) {
+	out := new(bytes.Buffer)
+
+	handler := httpserver.MiddlewareSequencer(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { panic("boom") }),
+		httpserver.AccessLog(&httpserver.AccessLogOptions{Format: httpserver.AccessLogJSON, Fields: httpserver.FieldStatus, Output: out}),
+		httpserver.PanicCatcher,
+	)
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	entry := make(map[string]any)
+	equal(t, nil, json.Unmarshal(out.Bytes(), &entry))
+	equal(t, float64(http.StatusInternalServerError), entry["status"])
+	equal(t, http.StatusInternalServerError, w.Code)
+}
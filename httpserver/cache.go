@@ -0,0 +1,371 @@
+package httpserver
+
+import (
+	"context"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/easysy/proton/internal/lru"
+)
+
+// CachedResponse is a complete response stored by the Cache middleware.
+type CachedResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+
+	// Stored is the time the response was stored, and Expires the time it stops being served.
+	Stored  time.Time
+	Expires time.Time
+}
+
+// CacheStore stores the responses of the Cache middleware by key.
+// Implementations must be safe for concurrent use; a store shared by several instances of a service
+// (e.g. Redis) may serialize the responses and expire them at CachedResponse.Expires.
+type CacheStore interface {
+	// Get returns the response stored under the key and true, or false if there is none.
+	Get(ctx context.Context, key string) (*CachedResponse, bool)
+	// Set stores the response under the key, replacing the previous one.
+	Set(ctx context.Context, key string, response *CachedResponse)
+}
+
+// MemoryStore is a CacheStore keeping responses in memory.
+// When the total size of the bodies exceeds the limit, the least recently used responses are evicted.
+type MemoryStore struct {
+	cache *lru.Cache[*CachedResponse]
+}
+
+// NewMemoryStore returns a MemoryStore holding at most maxSize bytes of bodies (64 MiB if maxSize is not positive).
+func NewMemoryStore(maxSize int64) *MemoryStore {
+	if maxSize <= 0 {
+		maxSize = 64 << 20
+	}
+
+	size := func(response *CachedResponse) int64 { return int64(len(response.Body)) }
+	expired := func(response *CachedResponse) bool { return !time.Now().Before(response.Expires) }
+
+	return &MemoryStore{cache: lru.New(maxSize, size, expired)}
+}
+
+func (s *MemoryStore) Get(_ context.Context, key string) (*CachedResponse, bool) {
+	return s.cache.Get(key)
+}
+
+func (s *MemoryStore) Set(_ context.Context, key string, response *CachedResponse) {
+	s.cache.Set(key, response)
+}
+
+// Len returns the number of stored responses.
+func (s *MemoryStore) Len() int {
+	return s.cache.Len()
+}
+
+// CacheOptions represents configuration for the Cache middleware.
+type CacheOptions struct {
+	// Store keeps the responses. Defaults to a MemoryStore of 64 MiB.
+	Store CacheStore
+
+	// TTL is how long a response is served from the cache if the handler sets no
+	// Cache-Control s-maxage or max-age directive. Defaults to one minute.
+	TTL time.Duration
+
+	// KeyHeaders lists the request headers whose values select different responses, e.g. "Accept-Language".
+	// A response varying on another header (see the Vary header) is not cached.
+	KeyHeaders []string
+
+	// MaxBodySize is the size of the largest body cached. Defaults to 1 MiB.
+	MaxBodySize int
+}
+
+// cacheableStatus lists the status codes of the responses the Cache middleware stores.
+var cacheableStatus = map[int]bool{
+	http.StatusOK:                   true,
+	http.StatusNonAuthoritativeInfo: true,
+	http.StatusNoContent:            true,
+	http.StatusMultipleChoices:      true,
+	http.StatusMovedPermanently:     true,
+	http.StatusPermanentRedirect:    true,
+	http.StatusNotFound:             true,
+	http.StatusMethodNotAllowed:     true,
+	http.StatusGone:                 true,
+	http.StatusRequestURITooLong:    true,
+	http.StatusNotImplemented:       true,
+}
+
+// Cache serves the responses to GET and HEAD requests from the store, keyed by the method, the host, the URL
+// and the values of KeyHeaders. A response is stored for its Cache-Control s-maxage or max-age, or for TTL.
+// Responses with Cache-Control no-store, no-cache or private, with Set-Cookie, with an uncacheable status code
+// or with a body larger than MaxBodySize are not stored; neither are responses to HEAD requests.
+// The Cache-Control directives of requests are ignored, so that clients cannot bypass the cache.
+//
+// Requests with an Authorization or a Cookie header bypass the cache: they are served only stored responses
+// marked as shared with Cache-Control public, s-maxage or must-revalidate, and their own responses are
+// stored only if they are marked so (RFC 9111, 3.5).
+//
+// Concurrent requests missing the same key wait for the first one, so that the handler computes
+// the response once (stampede protection). The waiting requests are served the stored response,
+// or are passed to the handler if it was not stored.
+//
+// A response served from the cache has the Age header and "Cache-Status: httpserver; hit" (RFC 9211);
+// other responses have "Cache-Status: httpserver; fwd=uri-miss". A stored 200 response is answered
+// with 304 Not Modified when the If-None-Match or If-Modified-Since header of the request matches its
+// ETag or Last-Modified header (see CheckPreconditions).
+//
+// Place Cache inside Compress, so that the uncompressed responses are stored.
+func Cache(opts *CacheOptions) func(http.Handler) http.Handler {
+	if opts == nil {
+		opts = &CacheOptions{}
+	}
+
+	store := opts.Store
+	if store == nil {
+		store = NewMemoryStore(0)
+	}
+
+	ttl := opts.TTL
+	if ttl <= 0 {
+		ttl = time.Minute
+	}
+
+	maxBodySize := opts.MaxBodySize
+	if maxBodySize <= 0 {
+		maxBodySize = 1 << 20
+	}
+
+	keyHeaders := make([]string, len(opts.KeyHeaders))
+	for i, name := range opts.KeyHeaders {
+		keyHeaders[i] = http.CanonicalHeaderKey(name)
+	}
+
+	var (
+		mu      sync.Mutex
+		flights = make(map[string]chan struct{})
+	)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.Method != http.MethodGet && r.Method != http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			ctx := r.Context()
+			key := responseCacheKey(r, keyHeaders)
+			credentialed := hasCredentials(r)
+
+			if cached, ok := store.Get(ctx, key); ok && (!credentialed || sharedWithCredentials(cached.Header)) {
+				writeCached(w, r, cached)
+				return
+			}
+
+			w.Header().Set("Cache-Status", "httpserver; fwd=uri-miss")
+
+			if r.Method == http.MethodHead {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			// A request with credentials may get a response of its own, so it neither waits for
+			// nor is waited for by other requests.
+			if credentialed {
+				dw := newDumpWriter(w, maxBodySize)
+				next.ServeHTTP(dw, r)
+
+				if cached := cacheableResponse(dw, keyHeaders, ttl, true); cached != nil {
+					store.Set(ctx, key, cached)
+				}
+				return
+			}
+
+			mu.Lock()
+			done, waiting := flights[key]
+			if !waiting {
+				done = make(chan struct{})
+				flights[key] = done
+			}
+			mu.Unlock()
+
+			if waiting {
+				select {
+				case <-done:
+				case <-ctx.Done():
+					return
+				}
+				if cached, ok := store.Get(ctx, key); ok {
+					writeCached(w, r, cached)
+					return
+				}
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			defer func() {
+				mu.Lock()
+				delete(flights, key)
+				mu.Unlock()
+				close(done)
+			}()
+
+			dw := newDumpWriter(w, maxBodySize)
+			next.ServeHTTP(dw, r)
+
+			if cached := cacheableResponse(dw, keyHeaders, ttl, false); cached != nil {
+				store.Set(ctx, key, cached)
+			}
+		})
+	}
+}
+
+// responseCacheKey returns the key of the responses to the request.
+func responseCacheKey(r *http.Request, keyHeaders []string) string {
+	var b strings.Builder
+	b.WriteString(http.MethodGet + " " + r.Host + r.URL.RequestURI())
+	for _, name := range keyHeaders {
+		b.WriteString("\n" + name + ": " + strings.Join(r.Header.Values(name), ","))
+	}
+	return b.String()
+}
+
+// hasCredentials reports whether the request carries credentials, which may make its response personal.
+func hasCredentials(r *http.Request) bool {
+	return r.Header.Get("Authorization") != "" || r.Header.Get("Cookie") != ""
+}
+
+// cacheControl returns the Cache-Control directives of the header by lower-case name, with unquoted values.
+func cacheControl(h http.Header) map[string]string {
+	directives := make(map[string]string)
+	for _, value := range h.Values("Cache-Control") {
+		for _, directive := range strings.Split(value, ",") {
+			name, arg, _ := strings.Cut(strings.TrimSpace(directive), "=")
+			if name = strings.ToLower(strings.TrimSpace(name)); name != "" {
+				directives[name] = strings.Trim(strings.TrimSpace(arg), `"`)
+			}
+		}
+	}
+	return directives
+}
+
+// sharedWithCredentials reports whether a response to a request with credentials may be shared
+// with other requests: it has the public, s-maxage or must-revalidate directive (RFC 9111, 3.5).
+func sharedWithCredentials(h http.Header) bool {
+	directives := cacheControl(h)
+	for _, name := range []string{"public", "s-maxage", "must-revalidate"} {
+		if _, ok := directives[name]; ok {
+			return true
+		}
+	}
+	return false
+}
+
+// cacheableResponse returns the response recorded by dw to be stored, or nil if it may not be stored.
+// The response to a request with credentials is stored only if it may be shared.
+func cacheableResponse(dw *dumpWriter, keyHeaders []string, ttl time.Duration, credentialed bool) *CachedResponse {
+	if dw.hijacked || !cacheableStatus[dw.Status()] || dw.Size() > int64(dw.body.Len()) {
+		return nil
+	}
+
+	h := dw.header
+	if h == nil {
+		h = dw.Header().Clone()
+	}
+
+	if h.Get("Set-Cookie") != "" {
+		return nil
+	}
+
+	for _, value := range h.Values("Vary") {
+		for _, name := range strings.Split(value, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name != "" && !slices.Contains(keyHeaders, name) {
+				return nil
+			}
+		}
+	}
+
+	if credentialed && !sharedWithCredentials(h) {
+		return nil
+	}
+
+	directives := cacheControl(h)
+
+	for _, name := range []string{"no-store", "no-cache", "private"} {
+		if _, ok := directives[name]; ok {
+			return nil
+		}
+	}
+
+	for _, name := range []string{"s-maxage", "max-age"} {
+		if value, ok := directives[name]; ok {
+			n, err := strconv.Atoi(value)
+			if err != nil || n <= 0 {
+				return nil
+			}
+			ttl = time.Duration(n) * time.Second
+			break
+		}
+	}
+
+	header := h.Clone()
+	header.Del("Cache-Status")
+
+	now := time.Now()
+
+	return &CachedResponse{
+		StatusCode: dw.Status(),
+		Header:     header,
+		Body:       slices.Clone(dw.body.Bytes()),
+		Stored:     now,
+		Expires:    now.Add(ttl),
+	}
+}
+
+// writeCached writes the stored response, or 304 Not Modified if the request has it already.
+func writeCached(w http.ResponseWriter, r *http.Request, cached *CachedResponse) {
+	h := w.Header()
+	for name, values := range cached.Header {
+		h[name] = slices.Clone(values)
+	}
+	h.Set("Age", strconv.FormatInt(int64(time.Since(cached.Stored)/time.Second), 10))
+	h.Set("Cache-Status", "httpserver; hit")
+
+	if notModified(r, cached) {
+		for _, name := range []string{"Content-Type", "Content-Length", "Content-Encoding"} {
+			h.Del(name)
+		}
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	if !bodyAllowed(cached.StatusCode) {
+		w.WriteHeader(cached.StatusCode)
+		return
+	}
+
+	h.Set("Content-Length", strconv.Itoa(len(cached.Body)))
+	w.WriteHeader(cached.StatusCode)
+
+	if r.Method != http.MethodHead {
+		_, _ = w.Write(cached.Body)
+	}
+}
+
+// notModified reports whether the If-None-Match or If-Modified-Since header of the request
+// matches the stored 200 response.
+func notModified(r *http.Request, cached *CachedResponse) bool {
+	if cached.StatusCode != http.StatusOK {
+		return false
+	}
+
+	p := &preconditions{
+		method:          r.Method,
+		ifNoneMatch:     r.Header.Get("If-None-Match"),
+		ifModifiedSince: r.Header.Get("If-Modified-Since"),
+	}
+	lastModified, _ := http.ParseTime(cached.Header.Get("Last-Modified"))
+
+	return p.evaluate(cached.Header.Get("ETag"), lastModified) == http.StatusNotModified
+}
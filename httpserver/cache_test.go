@@ -0,0 +1,211 @@
+package httpserver_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/easysy/proton/httpserver"
+)
+
+func TestCache(t *testing.T) {
+	var calls atomic.Int32
+
+	h := httpserver.Cache(&httpserver.CacheOptions{KeyHeaders: []string{"Accept-Language"}})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+
+			switch r.URL.Path {
+			case "/private":
+				w.Header().Set("Cache-Control", "private, max-age=60")
+			case "/vary":
+				w.Header().Set("Vary", "Accept-Language")
+			case "/cookie":
+				w.Header().Set("Vary", "Cookie")
+			case "/error":
+				w.WriteHeader(http.StatusInternalServerError)
+			}
+			_, _ = w.Write([]byte(r.URL.Path + " " + r.Header.Get("Accept-Language")))
+		}))
+
+	var tests = []struct {
+		method   string
+		target   string
+		language string
+		status   string
+		body     string
+		calls    int32
+	}{
+		{method: http.MethodGet, target: "/public?page=1", status: "httpserver; fwd=uri-miss", body: "/public ", calls: 1},
+		{method: http.MethodGet, target: "/public?page=1", status: "httpserver; hit", body: "/public ", calls: 1},
+		{method: http.MethodHead, target: "/public?page=1", status: "httpserver; hit", calls: 1},
+		{method: http.MethodGet, target: "/public?page=2", status: "httpserver; fwd=uri-miss", body: "/public ", calls: 2},
+		{method: http.MethodPost, target: "/public?page=1", body: "/public ", calls: 3},
+		{method: http.MethodGet, target: "/vary", language: "en", status: "httpserver; fwd=uri-miss", body: "/vary en", calls: 4},
+		{method: http.MethodGet, target: "/vary", language: "de", status: "httpserver; fwd=uri-miss", body: "/vary de", calls: 5},
+		{method: http.MethodGet, target: "/vary", language: "en", status: "httpserver; hit", body: "/vary en", calls: 5},
+		{method: http.MethodGet, target: "/private", status: "httpserver; fwd=uri-miss", body: "/private ", calls: 6},
+		{method: http.MethodGet, target: "/private", status: "httpserver; fwd=uri-miss", body: "/private ", calls: 7},
+		{method: http.MethodGet, target: "/cookie", status: "httpserver; fwd=uri-miss", body: "/cookie ", calls: 8},
+		{method: http.MethodGet, target: "/cookie", status: "httpserver; fwd=uri-miss", body: "/cookie ", calls: 9},
+		{method: http.MethodGet, target: "/error", status: "httpserver; fwd=uri-miss", body: "/error ", calls: 10},
+		{method: http.MethodGet, target: "/error", status: "httpserver; fwd=uri-miss", body: "/error ", calls: 11},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(test.method, test.target, nil)
+		if test.language != "" {
+			r.Header.Set("Accept-Language", test.language)
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		equal(t, test.status, w.Header().Get("Cache-Status"))
+		equal(t, test.body, w.Body.String())
+		equal(t, test.calls, calls.Load())
+	}
+}
+
+func TestCache_Conditional(t *testing.T) {
+	var calls atomic.Int32
+
+	lastModified := time.Date(2026, time.January, 2, 3, 4, 5, 0, time.UTC)
+
+	h := httpserver.Cache(nil)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			w.Header().Set("Content-Type", "text/plain")
+			w.Header().Set("ETag", `"v1"`)
+			w.Header().Set("Last-Modified", lastModified.Format(http.TimeFormat))
+			_, _ = w.Write([]byte("report"))
+		}))
+
+	var tests = []struct {
+		header string
+		value  string
+		code   int
+		body   string
+	}{
+		{code: http.StatusOK, body: "report"},
+		{header: "If-None-Match", value: `"v0", W/"v1"`, code: http.StatusNotModified},
+		{header: "If-None-Match", value: `"v2"`, code: http.StatusOK, body: "report"},
+		{header: "If-Modified-Since", value: lastModified.Format(http.TimeFormat), code: http.StatusNotModified},
+		{header: "If-Modified-Since", value: lastModified.Add(-time.Hour).Format(http.TimeFormat), code: http.StatusOK, body: "report"},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/report", nil)
+		if test.header != "" {
+			r.Header.Set(test.header, test.value)
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		equal(t, test.code, w.Code)
+		equal(t, test.body, w.Body.String())
+		equal(t, `"v1"`, w.Header().Get("ETag"))
+	}
+
+	equal(t, int32(1), calls.Load())
+}
+
+func TestCache_Authorization(t *testing.T) {
+	var calls atomic.Int32
+
+	h := httpserver.Cache(nil)(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+
+			if r.URL.Path == "/public" {
+				w.Header().Set("Cache-Control", "public, max-age=60")
+			}
+			_, _ = w.Write([]byte(r.URL.Path + " " + r.Header.Get("Authorization")))
+		}))
+
+	var tests = []struct {
+		target        string
+		authorization string
+		status        string
+		body          string
+		calls         int32
+	}{
+		{target: "/me", authorization: "Bearer alice", status: "httpserver; fwd=uri-miss", body: "/me Bearer alice", calls: 1},
+		{target: "/me", status: "httpserver; fwd=uri-miss", body: "/me ", calls: 2},
+		{target: "/me", authorization: "Bearer bob", status: "httpserver; fwd=uri-miss", body: "/me Bearer bob", calls: 3},
+		{target: "/me", status: "httpserver; hit", body: "/me ", calls: 3},
+		{target: "/public", authorization: "Bearer alice", status: "httpserver; fwd=uri-miss", body: "/public Bearer alice", calls: 4},
+		{target: "/public", status: "httpserver; hit", body: "/public Bearer alice", calls: 4},
+		{target: "/public", authorization: "Bearer bob", status: "httpserver; hit", body: "/public Bearer alice", calls: 4},
+	}
+
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, test.target, nil)
+		if test.authorization != "" {
+			r.Header.Set("Authorization", test.authorization)
+		}
+
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		equal(t, test.status, w.Header().Get("Cache-Status"))
+		equal(t, test.body, w.Body.String())
+		equal(t, test.calls, calls.Load())
+	}
+}
+
+func TestCache_Stampede(t *testing.T) {
+	var calls atomic.Int32
+
+	release := make(chan struct{})
+
+	h := httpserver.Cache(&httpserver.CacheOptions{TTL: time.Hour})(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			calls.Add(1)
+			<-release
+			_, _ = w.Write([]byte("computed"))
+		}))
+
+	var wg sync.WaitGroup
+
+	bodies := make([]string, 10)
+	for i := range bodies {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/slow", nil))
+			bodies[i] = w.Body.String()
+		}()
+	}
+
+	// Give the requests time to queue up behind the first one.
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	equal(t, int32(1), calls.Load())
+	for _, body := range bodies {
+		equal(t, "computed", body)
+	}
+}
+
+func TestMemoryStore_Expires(t *testing.T) {
+	store := httpserver.NewMemoryStore(0)
+	ctx := context.Background()
+
+	store.Set(ctx, "fresh", &httpserver.CachedResponse{Expires: time.Now().Add(time.Hour)})
+	store.Set(ctx, "expired", &httpserver.CachedResponse{Expires: time.Now().Add(-time.Second)})
+
+	_, ok := store.Get(ctx, "fresh")
+	equal(t, true, ok)
+
+	_, ok = store.Get(ctx, "expired")
+	equal(t, false, ok)
+	equal(t, 1, store.Len())
+}
@@ -0,0 +1,356 @@
+package httpserver
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Encoder is a content coding used by the Compress middleware, such as gzip.
+// Writers returned by New that implement Reset(io.Writer) are reused across responses.
+type Encoder struct {
+	// Name is the content coding token sent in the "Content-Encoding" header, e.g. "gzip" or "br".
+	Name string
+
+	// New returns a writer compressing to w. Close must flush all data to w without closing it.
+	New func(w io.Writer) (io.WriteCloser, error)
+}
+
+// GzipEncoder returns the gzip Encoder with the compression level, e.g. gzip.DefaultCompression.
+func GzipEncoder(level int) Encoder {
+	return Encoder{Name: "gzip", New: func(w io.Writer) (io.WriteCloser, error) {
+		return gzip.NewWriterLevel(w, level)
+	}}
+}
+
+// DeflateEncoder returns the deflate Encoder with the compression level, e.g. flate.DefaultCompression.
+// The "deflate" content coding is the zlib format, which is what clients expect.
+func DeflateEncoder(level int) Encoder {
+	return Encoder{Name: "deflate", New: func(w io.Writer) (io.WriteCloser, error) {
+		return zlib.NewWriterLevel(w, level)
+	}}
+}
+
+// DefaultCompressTypes are the media types compressed by default.
+// An entry may contain "*" matching any part of a type or subtype, as in path.Match.
+var DefaultCompressTypes = []string{
+	"text/*",
+	"application/json",
+	"application/*+json",
+	"application/xml",
+	"application/*+xml",
+	"application/javascript",
+	"application/x-ndjson",
+	"application/x-www-form-urlencoded",
+	"image/svg+xml",
+}
+
+// CompressOptions represents configuration for the Compress middleware.
+type CompressOptions struct {
+	// Encoders lists the supported content codings in the order of preference of the server,
+	// which breaks ties between codings equally preferred by the client.
+	// Defaults to gzip and deflate with the default compression level.
+	Encoders []Encoder
+
+	// MinSize is the minimal body size in bytes to compress. Smaller bodies are sent as is,
+	// since compressing them saves little or even grows them. Defaults to 1024; a negative value compresses any body.
+	// A response flushed before MinSize bytes are written is compressed, as it is likely a stream.
+	MinSize int
+
+	// ContentTypes lists the compressible media types. Defaults to DefaultCompressTypes.
+	ContentTypes []string
+}
+
+type compressor struct {
+	encoders []Encoder
+	pools    []sync.Pool
+	minSize  int
+	types    []string
+}
+
+func newCompressor(opts *CompressOptions) *compressor {
+	if opts == nil {
+		opts = &CompressOptions{}
+	}
+
+	c := &compressor{
+		encoders: opts.Encoders,
+		minSize:  opts.MinSize,
+		types:    opts.ContentTypes,
+	}
+
+	if len(c.encoders) == 0 {
+		c.encoders = []Encoder{GzipEncoder(gzip.DefaultCompression), DeflateEncoder(flate.DefaultCompression)}
+	}
+	if c.minSize == 0 {
+		c.minSize = 1024
+	}
+	if len(c.types) == 0 {
+		c.types = DefaultCompressTypes
+	}
+
+	c.pools = make([]sync.Pool, len(c.encoders))
+
+	return c
+}
+
+// negotiate returns the index of the encoder preferred by the Accept-Encoding header, or -1.
+func (c *compressor) negotiate(acceptEncoding string) int {
+	best, bestQ := -1, 0.0
+
+	for i, enc := range c.encoders {
+		q := acceptQuality(acceptEncoding, enc.Name)
+		if q > bestQ {
+			best, bestQ = i, q
+		}
+	}
+
+	return best
+}
+
+// acceptQuality returns the quality value of the coding in the Accept-Encoding header (RFC 9110, 12.5.3):
+// the weight of the coding itself, or of "*" if the coding is not listed.
+func acceptQuality(header, coding string) float64 {
+	wildcard := 0.0
+	for _, item := range strings.Split(header, ",") {
+		name, params, _ := strings.Cut(item, ";")
+		name = strings.TrimSpace(name)
+
+		q := 1.0
+		if value, ok := strings.CutPrefix(strings.TrimSpace(params), "q="); ok {
+			var err error
+			if q, err = strconv.ParseFloat(value, 64); err != nil {
+				q = 0
+			}
+		}
+
+		switch {
+		case strings.EqualFold(name, coding):
+			return q
+		case name == "*":
+			wildcard = q
+		}
+	}
+	return wildcard
+}
+
+func (c *compressor) compressible(contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, pattern := range c.types {
+		if ok, _ := path.Match(pattern, mediaType); ok {
+			return true
+		}
+	}
+	return false
+}
+
+// Compress compresses response bodies with the content coding preferred by the client in "Accept-Encoding".
+// Only responses of a compressible content type with at least MinSize bytes are compressed; responses that
+// already have a "Content-Encoding", partial content and responses without a body are sent as is.
+// "Vary: Accept-Encoding" is added to every response, and a strong ETag of a compressed response is made weak.
+// Streaming with http.Flusher is supported: a flush sends the data compressed so far.
+//
+// Place Compress outside DumpHttp to dump uncompressed bodies.
+func Compress(opts *CompressOptions) func(http.Handler) http.Handler {
+	c := newCompressor(opts)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			addVary(w.Header(), "Accept-Encoding")
+
+			enc := c.negotiate(r.Header.Get("Accept-Encoding"))
+			if enc < 0 || r.Method == http.MethodHead || r.Header.Get("Upgrade") != "" {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			cw := &compressWriter{ResponseWriter: w, c: c, enc: enc}
+			defer cw.close()
+
+			next.ServeHTTP(cw, r)
+		})
+	}
+}
+
+// addVary adds the header name to the "Vary" header unless it is already listed.
+func addVary(h http.Header, name string) {
+	for _, value := range h.Values("Vary") {
+		for _, field := range strings.Split(value, ",") {
+			if field = strings.TrimSpace(field); field == "*" || strings.EqualFold(field, name) {
+				return
+			}
+		}
+	}
+	h.Add("Vary", name)
+}
+
+// compressWriter buffers the beginning of the body until it can decide whether to compress it.
+type compressWriter struct {
+	http.ResponseWriter
+	c   *compressor
+	enc int
+
+	status   int
+	buf      []byte
+	decided  bool
+	hijacked bool
+	zw       io.WriteCloser
+}
+
+func (w *compressWriter) WriteHeader(statusCode int) {
+	// Informational headers (1xx) are sent at once and may be followed by the final one.
+	if w.decided || statusCode < http.StatusOK {
+		w.ResponseWriter.WriteHeader(statusCode)
+		return
+	}
+	if w.status == 0 {
+		w.status = statusCode
+	}
+}
+
+func (w *compressWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	if !w.decided {
+		w.buf = append(w.buf, p...)
+		if len(w.buf) < w.c.minSize {
+			return len(p), nil
+		}
+		if err := w.decide(true); err != nil {
+			return 0, err
+		}
+		return len(p), nil
+	}
+
+	if w.zw != nil {
+		return w.zw.Write(p)
+	}
+	return w.ResponseWriter.Write(p)
+}
+
+// decide sends the header, compressed if the response is eligible and compress is true,
+// and writes the buffered beginning of the body.
+func (w *compressWriter) decide(compress bool) error {
+	w.decided = true
+
+	h := w.Header()
+
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+
+	if h.Get("Content-Type") == "" && len(w.buf) > 0 {
+		h.Set("Content-Type", http.DetectContentType(w.buf))
+	}
+
+	compress = compress &&
+		w.status != http.StatusNoContent && w.status != http.StatusNotModified && w.status != http.StatusPartialContent &&
+		h.Get("Content-Encoding") == "" && h.Get("Content-Range") == "" &&
+		w.c.compressible(h.Get("Content-Type"))
+
+	if compress {
+		zw, err := w.newWriter()
+		if err != nil {
+			return err
+		}
+		w.zw = zw
+
+		h.Del("Content-Length")
+		h.Set("Content-Encoding", w.c.encoders[w.enc].Name)
+		if etag := h.Get("ETag"); etag != "" && !strings.HasPrefix(etag, "W/") {
+			h.Set("ETag", "W/"+etag)
+		}
+	}
+
+	w.ResponseWriter.WriteHeader(w.status)
+
+	buf := w.buf
+	w.buf = nil
+
+	if len(buf) == 0 {
+		return nil
+	}
+	if w.zw != nil {
+		_, err := w.zw.Write(buf)
+		return err
+	}
+	_, err := w.ResponseWriter.Write(buf)
+	return err
+}
+
+func (w *compressWriter) newWriter() (io.WriteCloser, error) {
+	if zw, ok := w.c.pools[w.enc].Get().(io.WriteCloser); ok {
+		zw.(interface{ Reset(io.Writer) }).Reset(w.ResponseWriter)
+		return zw, nil
+	}
+	return w.c.encoders[w.enc].New(w.ResponseWriter)
+}
+
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		// A flushed response is likely a stream, so it is compressed regardless of its size.
+		if err := w.decide(true); err != nil {
+			return
+		}
+	}
+	if f, ok := w.zw.(interface{ Flush() error }); ok {
+		_ = f.Flush()
+	}
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *compressWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil {
+		w.hijacked = true
+	}
+	return conn, rw, err
+}
+
+// Unwrap returns the original http.ResponseWriter for http.ResponseController.
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// close sends a response smaller than MinSize as is and finishes the compressed stream.
+func (w *compressWriter) close() {
+	if w.hijacked {
+		return
+	}
+
+	if !w.decided {
+		if w.status == 0 && len(w.buf) == 0 {
+			// The handler wrote nothing; let net/http send the default response.
+			return
+		}
+		if w.c.minSize < 0 {
+			_ = w.decide(true)
+		} else {
+			_ = w.decide(false)
+		}
+	}
+
+	if w.zw == nil {
+		return
+	}
+
+	_ = w.zw.Close()
+	if _, ok := w.zw.(interface{ Reset(io.Writer) }); ok {
+		w.c.pools[w.enc].Put(w.zw)
+	}
+	w.zw = nil
+}
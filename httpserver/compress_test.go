@@ -0,0 +1,166 @@
+package httpserver_test
+
+import (
+	"compress/gzip"
+	"compress/zlib"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/easysy/proton/httpserver"
+)
+
+func TestCompress(t *testing.T) {
+	large := strings.Repeat("hello, world! ", 100)
+
+	var tests = []struct {
+		name           string
+		acceptEncoding string
+		contentType    string
+		contentEncode  string
+		body           string
+		encoding       string
+	}{
+		{name: "gzip", acceptEncoding: "gzip, deflate", contentType: "text/plain", body: large, encoding: "gzip"},
+		{name: "deflate preferred", acceptEncoding: "gzip;q=0.5, deflate", contentType: "application/json", body: large, encoding: "deflate"},
+		{name: "wildcard", acceptEncoding: "*", contentType: "application/problem+json", body: large, encoding: "gzip"},
+		{name: "not accepted", acceptEncoding: "gzip;q=0, br", contentType: "text/plain", body: large},
+		{name: "too small", acceptEncoding: "gzip", contentType: "text/plain", body: "hello"},
+		{name: "not compressible", acceptEncoding: "gzip", contentType: "image/png", body: large},
+		{name: "already encoded", acceptEncoding: "gzip", contentType: "text/plain", contentEncode: "br", body: large},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			handler := httpserver.Compress(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Content-Type", test.contentType)
+				w.Header().Set("ETag", `"v1"`)
+				if test.contentEncode != "" {
+					w.Header().Set("Content-Encoding", test.contentEncode)
+				}
+				_, _ = io.WriteString(w, test.body[:len(test.body)/2])
+				_, _ = io.WriteString(w, test.body[len(test.body)/2:])
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept-Encoding", test.acceptEncoding)
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			equal(t, "Accept-Encoding", w.Header().Get("Vary"))
+
+			if test.encoding == "" {
+				equal(t, test.contentEncode, w.Header().Get("Content-Encoding"))
+				equal(t, `"v1"`, w.Header().Get("ETag"))
+				equal(t, test.body, w.Body.String())
+				return
+			}
+
+			equal(t, test.encoding, w.Header().Get("Content-Encoding"))
+			equal(t, `W/"v1"`, w.Header().Get("ETag"))
+			equal(t, true, w.Body.Len() < len(test.body))
+
+			var zr io.Reader
+			var err error
+			if test.encoding == "gzip" {
+				zr, err = gzip.NewReader(w.Body)
+			} else {
+				zr, err = zlib.NewReader(w.Body)
+			}
+			equal(t, nil, err)
+
+			b, err := io.ReadAll(zr)
+			equal(t, nil, err)
+			equal(t, test.body, string(b))
+		})
+	}
+}
+
+func TestCompress_Flush(t *testing.T) {
+	srv := httptest.NewServer(httpserver.Compress(nil)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/event-stream")
+		for _, event := range []string{"one", "two"} {
+			_, _ = io.WriteString(w, "data: "+event+"\n\n")
+			w.(http.Flusher).Flush()
+		}
+	})))
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL)
+	equal(t, nil, err)
+	defer func() { _ = resp.Body.Close() }()
+
+	// The transport requested gzip itself and decompressed the response transparently.
+	equal(t, true, resp.Uncompressed)
+
+	b, err := io.ReadAll(resp.Body)
+	equal(t, nil, err)
+	equal(t, "data: one\n\ndata: two\n\n", string(b))
+}
+
+func TestDecompress(t *testing.T) {
+	gzipped := func(s string) string {
+		buf := new(strings.Builder)
+		zw := gzip.NewWriter(buf)
+		_, _ = io.WriteString(zw, s)
+		_ = zw.Close()
+		return buf.String()
+	}
+
+	deflated := func(s string) string {
+		buf := new(strings.Builder)
+		zw := zlib.NewWriter(buf)
+		_, _ = io.WriteString(zw, s)
+		_ = zw.Close()
+		return buf.String()
+	}
+
+	handler := httpserver.Decompress(&httpserver.DecompressOptions{MaxSize: 10})(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		equal(t, "", r.Header.Get("Content-Encoding"))
+		b, err := io.ReadAll(r.Body)
+		if err != nil {
+			var tooLarge *http.MaxBytesError
+			equal(t, true, errors.As(err, &tooLarge))
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+			return
+		}
+		_, _ = w.Write(b)
+	}))
+
+	var tests = []struct {
+		name     string
+		encoding string
+		body     string
+		status   int
+		expect   string
+	}{
+		{name: "gzip", encoding: "gzip", body: gzipped("hello"), status: http.StatusOK, expect: "hello"},
+		{name: "deflate", encoding: "deflate", body: deflated("hello"), status: http.StatusOK, expect: "hello"},
+		{name: "identity", body: "hello", status: http.StatusOK, expect: "hello"},
+		{name: "too large", encoding: "gzip", body: gzipped(strings.Repeat("a", 11)), status: http.StatusRequestEntityTooLarge},
+		{name: "exactly the limit", encoding: "gzip", body: gzipped(strings.Repeat("a", 10)), status: http.StatusOK, expect: strings.Repeat("a", 10)},
+		{name: "malformed", encoding: "gzip", body: "hello", status: http.StatusBadRequest},
+		{name: "unsupported", encoding: "br", body: "hello", status: http.StatusUnsupportedMediaType},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", strings.NewReader(test.body))
+			if test.encoding != "" {
+				r.Header.Set("Content-Encoding", test.encoding)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			equal(t, test.status, w.Code)
+			if test.expect != "" {
+				equal(t, test.expect, w.Body.String())
+			}
+		})
+	}
+}
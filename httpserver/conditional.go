@@ -0,0 +1,165 @@
+package httpserver
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/easysy/proton/coder"
+)
+
+// ETagMode selects the entity tags computed by a Formatter created with WithETag.
+type ETagMode int
+
+const (
+	// ETagStrong tags a representation with a strong validator: byte-for-byte equal responses have equal tags.
+	ETagStrong ETagMode = iota + 1
+	// ETagWeak tags a representation with a weak validator (W/"..."), which tolerates a changed encoding,
+	// e.g. by the Compress middleware.
+	ETagWeak
+)
+
+// FormatterOption configures a Formatter created by NewFormatter.
+type FormatterOption func(f *protoFormatter)
+
+// WithETag enables conditional responses. WriteResponse encodes the value first and tags a successful
+// response with the ETag set by the handler, if any, or one computed over the encoded bytes.
+// The Last-Modified header set by the handler is used as well.
+// For GET and HEAD requests it then evaluates the preconditions of the request (see Conditional):
+// a matching If-None-Match or an unchanged resource since If-Modified-Since gives 304 Not Modified,
+// and a failed If-Match or If-Unmodified-Since gives 412 Precondition Failed.
+// Preconditions of other methods must be checked against the current state of the resource before it is
+// changed, with CheckPreconditions.
+func WithETag(mode ETagMode) FormatterOption {
+	return func(f *protoFormatter) {
+		f.etag = mode
+	}
+}
+
+type preconditionsCtxKey struct{}
+
+// preconditions holds the conditional headers of a request (RFC 9110, 13.1).
+type preconditions struct {
+	method            string
+	ifMatch           string
+	ifNoneMatch       string
+	ifModifiedSince   string
+	ifUnmodifiedSince string
+}
+
+// withPreconditions returns ctx holding the conditional headers of the request.
+func withPreconditions(ctx context.Context, r *http.Request) context.Context {
+	return context.WithValue(ctx, preconditionsCtxKey{}, &preconditions{
+		method:            r.Method,
+		ifMatch:           r.Header.Get("If-Match"),
+		ifNoneMatch:       r.Header.Get("If-None-Match"),
+		ifModifiedSince:   r.Header.Get("If-Modified-Since"),
+		ifUnmodifiedSince: r.Header.Get("If-Unmodified-Since"),
+	})
+}
+
+// Conditional makes the conditional headers of the request available to WriteResponse of a Formatter
+// created with WithETag and to CheckPreconditions. Handle does it by itself.
+func Conditional(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, r.WithContext(withPreconditions(r.Context(), r)))
+	})
+}
+
+// CheckPreconditions evaluates the conditional headers of the request of ctx (see Conditional) against
+// the current entity tag and modification time of the resource; either may be empty or zero if unknown.
+// It returns nil if the request may proceed, or an *Error with 412 Precondition Failed,
+// or 304 Not Modified for a GET or HEAD request whose representation has not changed.
+//
+// Call it in a handler of a write request before changing the resource, to implement optimistic
+// concurrency with If-Match.
+func CheckPreconditions(ctx context.Context, etag string, lastModified time.Time) error {
+	p, _ := ctx.Value(preconditionsCtxKey{}).(*preconditions)
+	if p == nil {
+		return nil
+	}
+
+	if status := p.evaluate(etag, lastModified); status != 0 {
+		return NewError(status, nil)
+	}
+	return nil
+}
+
+// evaluate returns the status code of a failed precondition, or 0, in the order of RFC 9110, 13.2.2.
+func (p *preconditions) evaluate(etag string, lastModified time.Time) int {
+	safe := p.method == http.MethodGet || p.method == http.MethodHead
+	lastModified = lastModified.Truncate(time.Second)
+
+	if p.ifMatch != "" {
+		if !matchETag(p.ifMatch, etag, true) {
+			return http.StatusPreconditionFailed
+		}
+	} else if p.ifUnmodifiedSince != "" && !lastModified.IsZero() {
+		if t, err := http.ParseTime(p.ifUnmodifiedSince); err == nil && lastModified.After(t) {
+			return http.StatusPreconditionFailed
+		}
+	}
+
+	if p.ifNoneMatch != "" {
+		if matchETag(p.ifNoneMatch, etag, false) {
+			if safe {
+				return http.StatusNotModified
+			}
+			return http.StatusPreconditionFailed
+		}
+	} else if p.ifModifiedSince != "" && safe && !lastModified.IsZero() {
+		if t, err := http.ParseTime(p.ifModifiedSince); err == nil && !lastModified.After(t) {
+			return http.StatusNotModified
+		}
+	}
+
+	return 0
+}
+
+// matchETag reports whether the list of entity tags of a precondition header matches the tag,
+// using the strong or the weak comparison. "*" matches any existing representation.
+func matchETag(list, etag string, strong bool) bool {
+	if strings.TrimSpace(list) == "*" {
+		return etag != ""
+	}
+	if etag == "" || (strong && strings.HasPrefix(etag, "W/")) {
+		return false
+	}
+
+	opaque := strings.TrimPrefix(etag, "W/")
+
+	for _, candidate := range strings.Split(list, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if strong && strings.HasPrefix(candidate, "W/") {
+			continue
+		}
+		if strings.TrimPrefix(candidate, "W/") == opaque {
+			return true
+		}
+	}
+	return false
+}
+
+// NewETag returns an entity tag computed over the bytes of a representation.
+func NewETag(p []byte, mode ETagMode) string {
+	sum := sha256.Sum256(p)
+	etag := `"` + base64.RawURLEncoding.EncodeToString(sum[:16]) + `"`
+	if mode == ETagWeak {
+		etag = "W/" + etag
+	}
+	return etag
+}
+
+// ETagOf encodes v with the encoder and returns the entity tag of the encoded bytes,
+// e.g. to check the preconditions of a write request against the current state of the resource.
+func ETagOf(ctx context.Context, enc coder.Encoder, v any, mode ETagMode) (string, error) {
+	buf := new(bytes.Buffer)
+	if err := enc.Encode(ctx, buf, v); err != nil {
+		return "", err
+	}
+	return NewETag(buf.Bytes(), mode), nil
+}
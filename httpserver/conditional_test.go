@@ -0,0 +1,193 @@
+package httpserver_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/easysy/proton/httpserver"
+)
+
+type document struct {
+	Text string `json:"text"`
+}
+
+func TestFormatter_ETag(t *testing.T) {
+	f := httpserver.NewFormatter(cdrJSON, httpserver.WithETag(httpserver.ETagStrong))
+
+	doc := &document{Text: "hello"}
+	etag, err := httpserver.ETagOf(context.Background(), f, doc, httpserver.ETagStrong)
+	equal(t, nil, err)
+
+	modified := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	var tests = []struct {
+		name         string
+		method       string
+		header       http.Header
+		handlerETag  string
+		lastModified time.Time
+		status       int
+		etag         string
+		body         string
+	}{
+		{
+			name:   "unconditional",
+			method: http.MethodGet,
+			status: http.StatusOK,
+			etag:   etag,
+			body:   `{"text":"hello"}`,
+		},
+		{
+			name:   "if-none-match",
+			method: http.MethodGet,
+			header: http.Header{"If-None-Match": {`"other", ` + etag}},
+			status: http.StatusNotModified,
+			etag:   etag,
+		},
+		{
+			name:   "if-none-match weak",
+			method: http.MethodHead,
+			header: http.Header{"If-None-Match": {"W/" + etag}},
+			status: http.StatusNotModified,
+			etag:   etag,
+		},
+		{
+			name:   "if-none-match changed",
+			method: http.MethodGet,
+			header: http.Header{"If-None-Match": {`"other"`}},
+			status: http.StatusOK,
+			etag:   etag,
+			body:   `{"text":"hello"}`,
+		},
+		{
+			name:         "if-modified-since",
+			method:       http.MethodGet,
+			header:       http.Header{"If-Modified-Since": {modified.Format(http.TimeFormat)}},
+			lastModified: modified,
+			status:       http.StatusNotModified,
+			etag:         etag,
+		},
+		{
+			name:         "if-modified-since modified",
+			method:       http.MethodGet,
+			header:       http.Header{"If-Modified-Since": {modified.Add(-time.Hour).Format(http.TimeFormat)}},
+			lastModified: modified,
+			status:       http.StatusOK,
+			etag:         etag,
+			body:         `{"text":"hello"}`,
+		},
+		{
+			name:   "if-match failed",
+			method: http.MethodGet,
+			header: http.Header{"If-Match": {`"other"`}},
+			status: http.StatusPreconditionFailed,
+			body:   `{"error":"Precondition Failed"}`,
+		},
+		{
+			name:        "handler etag",
+			method:      http.MethodGet,
+			header:      http.Header{"If-None-Match": {`"v2"`}},
+			handlerETag: `"v2"`,
+			status:      http.StatusNotModified,
+			etag:        `"v2"`,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			h := httpserver.Conditional(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if test.handlerETag != "" {
+					w.Header().Set("ETag", test.handlerETag)
+				}
+				if !test.lastModified.IsZero() {
+					w.Header().Set("Last-Modified", test.lastModified.Format(http.TimeFormat))
+				}
+				f.WriteResponse(r.Context(), w, http.StatusOK, doc)
+			}))
+
+			r := httptest.NewRequest(test.method, "/doc", nil)
+			for k, v := range test.header {
+				r.Header[k] = v
+			}
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			equal(t, test.status, w.Code)
+			equal(t, test.etag, w.Header().Get("ETag"))
+			if test.method != http.MethodHead {
+				equal(t, test.body, strings.TrimSpace(w.Body.String()))
+			}
+		})
+	}
+}
+
+func TestCheckPreconditions(t *testing.T) {
+	f := httpserver.NewFormatter(cdrJSON, httpserver.WithETag(httpserver.ETagStrong))
+
+	current := &document{Text: "v1"}
+
+	h := httpserver.Handle(f, func(ctx context.Context, in *document) (*document, error) {
+		etag, err := httpserver.ETagOf(ctx, f, current, httpserver.ETagStrong)
+		if err != nil {
+			return nil, err
+		}
+		if err = httpserver.CheckPreconditions(ctx, etag, time.Time{}); err != nil {
+			return nil, err
+		}
+		current = in
+		return in, nil
+	})
+
+	etag, err := httpserver.ETagOf(context.Background(), f, current, httpserver.ETagStrong)
+	equal(t, nil, err)
+
+	var tests = []struct {
+		name    string
+		ifMatch string
+		status  int
+		text    string
+	}{
+		{
+			name:    "stale",
+			ifMatch: `"stale"`,
+			status:  http.StatusPreconditionFailed,
+			text:    "v1",
+		},
+		{
+			name:    "weak",
+			ifMatch: "W/" + etag,
+			status:  http.StatusPreconditionFailed,
+			text:    "v1",
+		},
+		{
+			name:    "current",
+			ifMatch: etag,
+			status:  http.StatusOK,
+			text:    "v2",
+		},
+		{
+			name:    "lost update",
+			ifMatch: etag,
+			status:  http.StatusPreconditionFailed,
+			text:    "v2",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPut, "/doc", strings.NewReader(`{"text":"v2"}`))
+			r.Header.Set("If-Match", test.ifMatch)
+
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			equal(t, test.status, w.Code)
+			equal(t, test.text, current.Text)
+		})
+	}
+}
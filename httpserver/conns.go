@@ -0,0 +1,136 @@
+package httpserver
+
+import (
+	"crypto/tls"
+	"io"
+	"net"
+	"net/http"
+	"sync"
+)
+
+// ConnStats holds the number of client connections tracked by the Controller, grouped by state.
+type ConnStats struct {
+	New      int
+	Active   int
+	Idle     int
+	Hijacked int
+}
+
+// Total returns the total number of tracked connections.
+func (s ConnStats) Total() int {
+	return s.New + s.Active + s.Idle + s.Hijacked
+}
+
+// connTracker keeps the state of every connection accepted by the server.
+// Hijacked connections are no longer tracked by *http.Server, so they stay here until they are closed.
+type connTracker struct {
+	mu    sync.Mutex
+	conns map[net.Conn]http.ConnState
+}
+
+func newConnTracker() *connTracker {
+	return &connTracker{conns: make(map[net.Conn]http.ConnState)}
+}
+
+// hook returns a ConnState callback that records state changes and then calls next, if any.
+// Connections are tracked by the connection accepted from the listener, which a *tls.Conn wraps,
+// so that closing a hijacked TLS connection removes it as well.
+func (t *connTracker) hook(next func(net.Conn, http.ConnState)) func(net.Conn, http.ConnState) {
+	return func(conn net.Conn, state http.ConnState) {
+		key := conn
+		if tc, ok := conn.(*tls.Conn); ok {
+			key = tc.NetConn()
+		}
+
+		t.mu.Lock()
+		if state == http.StateClosed {
+			delete(t.conns, key)
+		} else {
+			t.conns[key] = state
+		}
+		t.mu.Unlock()
+
+		if next != nil {
+			next(conn, state)
+		}
+	}
+}
+
+func (t *connTracker) remove(conn net.Conn) {
+	t.mu.Lock()
+	delete(t.conns, conn)
+	t.mu.Unlock()
+}
+
+func (t *connTracker) stats() (s ConnStats) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, state := range t.conns {
+		switch state {
+		case http.StateNew:
+			s.New++
+		case http.StateActive:
+			s.Active++
+		case http.StateIdle:
+			s.Idle++
+		case http.StateHijacked:
+			s.Hijacked++
+		}
+	}
+	return
+}
+
+// closeAll closes every connection that is still open.
+func (t *connTracker) closeAll() {
+	t.mu.Lock()
+	conns := make([]net.Conn, 0, len(t.conns))
+	for conn := range t.conns {
+		conns = append(conns, conn)
+	}
+	t.mu.Unlock()
+
+	for _, conn := range conns {
+		_ = conn.Close()
+	}
+}
+
+// trackedListener wraps accepted connections so that closing a hijacked connection is noticed.
+type trackedListener struct {
+	net.Listener
+	t *connTracker
+}
+
+func (l *trackedListener) Accept() (net.Conn, error) {
+	conn, err := l.Listener.Accept()
+	if err != nil {
+		return nil, err
+	}
+	return &trackedConn{Conn: conn, t: l.t}, nil
+}
+
+type trackedConn struct {
+	net.Conn
+	t *connTracker
+}
+
+func (c *trackedConn) Close() error {
+	c.t.remove(c)
+	return c.Conn.Close()
+}
+
+// ReadFrom keeps the sendfile optimization of the underlying connection.
+func (c *trackedConn) ReadFrom(r io.Reader) (int64, error) {
+	if rf, ok := c.Conn.(io.ReaderFrom); ok {
+		return rf.ReadFrom(r)
+	}
+	return io.Copy(struct{ io.Writer }{c.Conn}, r)
+}
+
+// CloseWrite lets *http.Server half-close the connection when it is supported.
+func (c *trackedConn) CloseWrite() error {
+	if cw, ok := c.Conn.(interface{ CloseWrite() error }); ok {
+		return cw.CloseWrite()
+	}
+	return nil
+}
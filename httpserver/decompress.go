@@ -0,0 +1,137 @@
+package httpserver
+
+import (
+	"bufio"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"io"
+	"net/http"
+	"strings"
+)
+
+// DecompressOptions represents configuration for the Decompress middleware.
+type DecompressOptions struct {
+	// MaxSize is the maximal size in bytes of a decompressed request body.
+	// Reading beyond it fails with *http.MaxBytesError. Defaults to 10 MiB; a negative value disables the limit.
+	MaxSize int64
+}
+
+// Decompress transparently decodes request bodies with the "gzip" or "deflate" Content-Encoding.
+// The "Content-Encoding" and "Content-Length" headers are removed from the request passed to the handler.
+// The decompressed size is limited by MaxSize to protect against decompression bombs; a handler reading
+// beyond it gets *http.MaxBytesError (Handle answers it with 413 Request Entity Too Large).
+// A request with an unsupported coding is answered with 415 Unsupported Media Type and
+// a request with a malformed body with 400 Bad Request.
+func Decompress(opts *DecompressOptions) func(http.Handler) http.Handler {
+	if opts == nil {
+		opts = &DecompressOptions{}
+	}
+
+	limit := opts.MaxSize
+	if limit == 0 {
+		limit = 10 << 20
+	}
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			codings := contentCodings(r.Header)
+			if len(codings) == 0 || r.Body == nil || r.Body == http.NoBody {
+				next.ServeHTTP(w, r)
+				return
+			}
+
+			body := io.Reader(r.Body)
+
+			// Codings are listed in the order they were applied, so they are decoded in reverse.
+			for i := len(codings) - 1; i >= 0; i-- {
+				var err error
+				switch codings[i] {
+				case "gzip", "x-gzip":
+					body, err = gzip.NewReader(body)
+				case "deflate":
+					body, err = newDeflateReader(body)
+				default:
+					w.Header().Set("Accept-Encoding", "gzip, deflate")
+					http.Error(w, http.StatusText(http.StatusUnsupportedMediaType), http.StatusUnsupportedMediaType)
+					return
+				}
+				if err != nil {
+					http.Error(w, "invalid "+codings[i]+" request body", http.StatusBadRequest)
+					return
+				}
+			}
+
+			if limit > 0 {
+				body = &limitedReader{r: body, n: limit, limit: limit}
+			}
+
+			r = r.Clone(r.Context())
+			r.Body = struct {
+				io.Reader
+				io.Closer
+			}{body, r.Body}
+			r.Header.Del("Content-Encoding")
+			r.Header.Del("Content-Length")
+			r.ContentLength = -1
+
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+// contentCodings returns the content codings of the message other than "identity", in lower case.
+func contentCodings(h http.Header) []string {
+	var codings []string
+	for _, value := range h.Values("Content-Encoding") {
+		for _, coding := range strings.Split(value, ",") {
+			if coding = strings.ToLower(strings.TrimSpace(coding)); coding != "" && coding != "identity" {
+				codings = append(codings, coding)
+			}
+		}
+	}
+	return codings
+}
+
+// newDeflateReader returns a reader of the "deflate" coding, which is the zlib format,
+// falling back to raw deflate data sent by some non-conforming clients.
+func newDeflateReader(r io.Reader) (io.Reader, error) {
+	br := bufio.NewReader(r)
+
+	header, err := br.Peek(2)
+	if err != nil {
+		return nil, err
+	}
+
+	// A zlib header has the deflate method and its check bits make it a multiple of 31.
+	if header[0]&0x0f == 8 && (uint16(header[0])<<8|uint16(header[1]))%31 == 0 {
+		return zlib.NewReader(br)
+	}
+	return flate.NewReader(br), nil
+}
+
+// limitedReader fails with *http.MaxBytesError when more than limit bytes are read.
+type limitedReader struct {
+	r     io.Reader
+	n     int64
+	limit int64
+}
+
+func (l *limitedReader) Read(p []byte) (int, error) {
+	if l.n < 0 {
+		return 0, &http.MaxBytesError{Limit: l.limit}
+	}
+
+	// Read one byte more than allowed to detect an oversized body.
+	if int64(len(p)) > l.n+1 {
+		p = p[:l.n+1]
+	}
+
+	n, err := l.r.Read(p)
+	l.n -= int64(n)
+
+	if l.n < 0 {
+		return n + int(l.n), &http.MaxBytesError{Limit: l.limit}
+	}
+	return n, err
+}
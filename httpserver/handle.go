@@ -0,0 +1,354 @@
+package httpserver
+
+import (
+	"context"
+	"encoding"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"reflect"
+	"strconv"
+	"time"
+
+	"github.com/easysy/proton/coder"
+	"github.com/easysy/proton/validate"
+)
+
+// StatusCoder is implemented by errors and responses that choose their own HTTP status code.
+type StatusCoder interface {
+	StatusCode() int
+}
+
+// Error is an error with an HTTP status code, written by Handle and WriteError as the response body.
+type Error struct {
+	// Status is the HTTP status code of the response.
+	Status int `json:"-" xml:"-"`
+
+	// Message is the error message sent to the client.
+	Message string `json:"error" xml:"error"`
+
+	// Details is optional additional data sent to the client, e.g. invalid fields.
+	Details any `json:"details,omitempty" xml:"details,omitempty"`
+
+	err error
+}
+
+// NewError returns an Error with the status code and the message of err,
+// or the status text if err is nil. The error is available through errors.Unwrap.
+func NewError(status int, err error) *Error {
+	e := &Error{Status: status, Message: http.StatusText(status), err: err}
+	if err != nil {
+		e.Message = err.Error()
+	}
+	return e
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func (e *Error) Unwrap() error {
+	return e.err
+}
+
+// StatusCode returns the HTTP status code of the error.
+func (e *Error) StatusCode() int {
+	return e.Status
+}
+
+// WriteError writes the error with the formatter.
+// An *Error in the chain of err is written as is. A validate.Errors is written as
+// 422 Unprocessable Entity with the failed fields in Error.Details. Any other error is written with the status code of
+// the first StatusCoder in its chain, or 500 Internal Server Error, and with its message if the status
+// is below 500. Errors with a 5xx status are logged, and their message is not sent, so that internal
+// details are not exposed.
+func WriteError(ctx context.Context, f Formatter, w http.ResponseWriter, err error) {
+	var e *Error
+	var fields validate.Errors
+
+	switch {
+	case errors.As(err, &e):
+	case errors.As(err, &fields):
+		e = &Error{Status: http.StatusUnprocessableEntity, Message: "validation failed", Details: fields, err: err}
+	default:
+		status := http.StatusInternalServerError
+
+		var sc StatusCoder
+		if errors.As(err, &sc) {
+			status = sc.StatusCode()
+		}
+
+		if status < http.StatusInternalServerError {
+			e = NewError(status, err)
+		} else {
+			e = NewError(status, nil)
+		}
+	}
+
+	if e.Status >= http.StatusInternalServerError {
+		slog.ErrorContext(ctx, "handle request", "error", err)
+	}
+
+	f.WriteResponse(ctx, w, e.Status, e)
+}
+
+// Handle returns a handler that binds the request to In, calls fn and writes its result with the formatter.
+//
+// In must be a struct or a pointer to a struct. The request body, if any, is decoded into In by the formatter;
+// then the fields tagged `path:"name"`, `query:"name"` and `header:"Name"` are set from the path values
+// (see http.Request.PathValue), the query parameters and the request headers, overriding the body.
+// Tagged fields may be strings, booleans, numbers, time.Duration, types implementing encoding.TextUnmarshaler,
+// pointers to them, and slices of them for repeated query parameters and headers.
+// A request that cannot be bound is answered with 400 Bad Request, or 413 Request Entity Too Large
+// if the body exceeds a limit set by http.MaxBytesReader or Decompress.
+// The bound input is then validated by validate.Struct; a request failing validation is answered
+// with 422 Unprocessable Entity listing the invalid fields (see WriteError). The validator of the decoder,
+// if any (see coder.WithValidator), is not called, as the body alone may lack the tagged fields.
+// Handle panics if a validate tag of In is invalid.
+//
+// Out is written with 200 OK, or with its own status code if it implements StatusCoder.
+// A nil Out (e.g. a nil pointer) is written as 204 No Content. An error returned by fn is written by WriteError.
+// The conditional headers of the request are available to fn and the formatter, as with Conditional.
+func Handle[In, Out any](f Formatter, fn func(ctx context.Context, in In) (Out, error)) http.Handler {
+	bind := newBinder(reflect.TypeFor[In]())
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		if ctx.Value(preconditionsCtxKey{}) == nil {
+			ctx = withPreconditions(ctx, r)
+		}
+
+		var in In
+		if err := bind(f, r, &in); err != nil {
+			WriteError(ctx, f, w, err)
+			return
+		}
+
+		out, err := fn(ctx, in)
+		if err != nil {
+			WriteError(ctx, f, w, err)
+			return
+		}
+
+		if isNil(out) {
+			f.WriteResponse(ctx, w, http.StatusNoContent, nil)
+			return
+		}
+
+		status := http.StatusOK
+		if sc, ok := any(out).(StatusCoder); ok {
+			status = sc.StatusCode()
+		}
+
+		f.WriteResponse(ctx, w, status, out)
+	})
+}
+
+func isNil(v any) bool {
+	if v == nil {
+		return true
+	}
+	switch rv := reflect.ValueOf(v); rv.Kind() {
+	case reflect.Pointer, reflect.Map, reflect.Slice, reflect.Interface:
+		return rv.IsNil()
+	default:
+		return false
+	}
+}
+
+// fieldBinding binds a request value to a struct field.
+type fieldBinding struct {
+	index  []int
+	source string
+	name   string
+}
+
+var bindSources = []string{"path", "query", "header"}
+
+// newBinder returns a function binding the request to a value of the type, which must be a struct
+// or a pointer to a struct. It panics if a tagged field has an unsupported type.
+func newBinder(t reflect.Type) func(f Formatter, r *http.Request, v any) error {
+	ptr := t.Kind() == reflect.Pointer
+	if ptr {
+		t = t.Elem()
+	}
+	if t.Kind() != reflect.Struct {
+		panic(fmt.Sprintf("httpserver: Handle input must be a struct or a pointer to a struct, got %s", t))
+	}
+
+	bindings := fieldBindings(t, nil)
+	validate.Compile(t)
+
+	return func(f Formatter, r *http.Request, v any) error {
+		rv := reflect.ValueOf(v).Elem()
+		if ptr {
+			rv.Set(reflect.New(t))
+			rv = rv.Elem()
+		}
+
+		if r.Body != nil && r.Body != http.NoBody {
+			if err := f.Decode(coder.WithoutValidation(r.Context()), r.Body, rv.Addr().Interface()); err != nil {
+				var tooLarge *http.MaxBytesError
+				if errors.As(err, &tooLarge) {
+					return NewError(http.StatusRequestEntityTooLarge, err)
+				}
+				return NewError(http.StatusBadRequest, fmt.Errorf("invalid request body: %w", err))
+			}
+		}
+
+		query := r.URL.Query()
+
+		for _, b := range bindings {
+			var values []string
+			switch b.source {
+			case "path":
+				if value := r.PathValue(b.name); value != "" {
+					values = []string{value}
+				}
+			case "query":
+				values = query[b.name]
+			case "header":
+				values = r.Header.Values(b.name)
+			}
+
+			if len(values) == 0 {
+				continue
+			}
+
+			if err := setField(rv.FieldByIndex(b.index), values); err != nil {
+				var ne *strconv.NumError
+				if errors.As(err, &ne) {
+					err = ne.Err
+				}
+				return NewError(http.StatusBadRequest, fmt.Errorf("invalid %s parameter %q: %w", b.source, b.name, err))
+			}
+		}
+
+		return validate.Struct(rv.Addr().Interface())
+	}
+}
+
+func fieldBindings(t reflect.Type, index []int) []fieldBinding {
+	var bindings []fieldBinding
+
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		fieldIndex := append(append([]int(nil), index...), i)
+
+		if field.Anonymous && field.Type.Kind() == reflect.Struct {
+			bindings = append(bindings, fieldBindings(field.Type, fieldIndex)...)
+			continue
+		}
+
+		for _, source := range bindSources {
+			name, ok := field.Tag.Lookup(source)
+			if !ok || name == "" || name == "-" {
+				continue
+			}
+			if !settable(field.Type) {
+				panic(fmt.Sprintf("httpserver: unsupported type %s of field %s tagged %s", field.Type, field.Name, source))
+			}
+			bindings = append(bindings, fieldBinding{index: fieldIndex, source: source, name: name})
+		}
+	}
+
+	return bindings
+}
+
+var (
+	textUnmarshalerType = reflect.TypeFor[encoding.TextUnmarshaler]()
+	durationType        = reflect.TypeFor[time.Duration]()
+)
+
+func settable(t reflect.Type) bool {
+	if reflect.PointerTo(t).Implements(textUnmarshalerType) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Pointer:
+		return t.Elem().Kind() != reflect.Pointer && settable(t.Elem())
+	case reflect.Slice:
+		return t.Elem().Kind() != reflect.Slice && settable(t.Elem())
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64:
+		return true
+	default:
+		return false
+	}
+}
+
+// setField sets the field to the values; all of them for a slice, the first one otherwise.
+func setField(v reflect.Value, values []string) error {
+	if v.Kind() == reflect.Slice && !reflect.PointerTo(v.Type()).Implements(textUnmarshalerType) {
+		s := reflect.MakeSlice(v.Type(), len(values), len(values))
+		for i, value := range values {
+			if err := setValue(s.Index(i), value); err != nil {
+				return err
+			}
+		}
+		v.Set(s)
+		return nil
+	}
+	return setValue(v, values[0])
+}
+
+func setValue(v reflect.Value, s string) error {
+	if u, ok := v.Addr().Interface().(encoding.TextUnmarshaler); ok {
+		return u.UnmarshalText([]byte(s))
+	}
+
+	if v.Type() == durationType {
+		d, err := time.ParseDuration(s)
+		if err != nil {
+			return err
+		}
+		v.SetInt(int64(d))
+		return nil
+	}
+
+	switch v.Kind() {
+	case reflect.Pointer:
+		p := reflect.New(v.Type().Elem())
+		if err := setValue(p.Elem(), s); err != nil {
+			return err
+		}
+		v.Set(p)
+	case reflect.String:
+		v.SetString(s)
+	case reflect.Bool:
+		b, err := strconv.ParseBool(s)
+		if err != nil {
+			return err
+		}
+		v.SetBool(b)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		n, err := strconv.ParseInt(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetInt(n)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		n, err := strconv.ParseUint(s, 10, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetUint(n)
+	case reflect.Float32, reflect.Float64:
+		n, err := strconv.ParseFloat(s, v.Type().Bits())
+		if err != nil {
+			return err
+		}
+		v.SetFloat(n)
+	default:
+		return fmt.Errorf("unsupported type %s", v.Type())
+	}
+
+	return nil
+}
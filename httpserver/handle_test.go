@@ -0,0 +1,185 @@
+package httpserver_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/easysy/proton/coder"
+	"github.com/easysy/proton/httpserver"
+	"github.com/easysy/proton/validate"
+)
+
+type handleInput struct {
+	ID      int           `path:"id" json:"-"`
+	Tags    []string      `query:"tag" json:"-"`
+	Limit   *uint         `query:"limit" json:"-"`
+	Timeout time.Duration `query:"timeout" json:"-"`
+	Tenant  string        `header:"X-Tenant" json:"-"`
+	Name    string        `json:"name" validate:"required,max=10"`
+}
+
+type handleOutput struct {
+	ID      int      `json:"id"`
+	Name    string   `json:"name"`
+	Tags    []string `json:"tags"`
+	Limit   uint     `json:"limit"`
+	Timeout string   `json:"timeout"`
+	Tenant  string   `json:"tenant"`
+}
+
+func (o *handleOutput) StatusCode() int {
+	return http.StatusCreated
+}
+
+var errConflict = errors.New("conflict")
+
+type conflictError struct{}
+
+func (conflictError) Error() string   { return "already exists" }
+func (conflictError) StatusCode() int { return http.StatusConflict }
+
+func TestHandle(t *testing.T) {
+	f := httpserver.NewFormatter(coder.NewCoder("application/json", json.Marshal, json.Unmarshal))
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /users/{id}", httpserver.Handle(f, func(ctx context.Context, in *handleInput) (*handleOutput, error) {
+		switch in.Name {
+		case "exists":
+			return nil, fmt.Errorf("create: %w", conflictError{})
+		case "fail":
+			return nil, errConflict
+		case "empty":
+			return nil, nil
+		}
+
+		out := &handleOutput{ID: in.ID, Name: in.Name, Tags: in.Tags, Timeout: in.Timeout.String(), Tenant: in.Tenant}
+		if in.Limit != nil {
+			out.Limit = *in.Limit
+		}
+		return out, nil
+	}))
+
+	var tests = []struct {
+		name   string
+		target string
+		body   string
+		status int
+		expect string
+	}{
+		{
+			name:   "bound input",
+			target: "/users/7?tag=a&tag=b&limit=10&timeout=2s",
+			body:   `{"name":"gopher"}`,
+			status: http.StatusCreated,
+			expect: `{"id":7,"name":"gopher","tags":["a","b"],"limit":10,"timeout":"2s","tenant":"acme"}`,
+		},
+		{
+			name:   "invalid path value",
+			target: "/users/x",
+			body:   `{"name":"gopher"}`,
+			status: http.StatusBadRequest,
+			expect: `{"error":"invalid path parameter \"id\": invalid syntax"}`,
+		},
+		{
+			name:   "invalid body",
+			target: "/users/7",
+			body:   `{`,
+			status: http.StatusBadRequest,
+		},
+		{
+			name:   "invalid input",
+			target: "/users/7",
+			body:   `{"name":""}`,
+			status: http.StatusUnprocessableEntity,
+			expect: `{"error":"validation failed","details":[{"field":"name","rule":"required","message":"is required"}]}`,
+		},
+		{
+			name:   "status error",
+			target: "/users/7",
+			body:   `{"name":"exists"}`,
+			status: http.StatusConflict,
+			expect: `{"error":"create: already exists"}`,
+		},
+		{
+			name:   "internal error",
+			target: "/users/7",
+			body:   `{"name":"fail"}`,
+			status: http.StatusInternalServerError,
+			expect: `{"error":"Internal Server Error"}`,
+		},
+		{
+			name:   "no content",
+			target: "/users/7",
+			body:   `{"name":"empty"}`,
+			status: http.StatusNoContent,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, test.target, strings.NewReader(test.body))
+			r.Header.Set("X-Tenant", "acme")
+
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, r)
+
+			equal(t, test.status, w.Code)
+			if test.expect != "" {
+				equal(t, test.expect, strings.TrimSpace(w.Body.String()))
+			}
+		})
+	}
+}
+
+type validatedInput struct {
+	ID   int    `path:"id" json:"-" validate:"required"`
+	Name string `json:"name" validate:"required"`
+}
+
+func TestHandle_DecoderValidator(t *testing.T) {
+	f := httpserver.NewFormatter(coder.NewCoder("application/json", json.Marshal, json.Unmarshal, coder.WithValidator(validate.Struct)))
+
+	mux := http.NewServeMux()
+	mux.Handle("POST /users/{id}", httpserver.Handle(f, func(ctx context.Context, in validatedInput) (*handleOutput, error) {
+		return &handleOutput{ID: in.ID, Name: in.Name}, nil
+	}))
+
+	var tests = []struct {
+		name   string
+		body   string
+		status int
+	}{
+		{name: "path field required by the validator", body: `{"name":"gopher"}`, status: http.StatusCreated},
+		{name: "invalid body field", body: `{"name":""}`, status: http.StatusUnprocessableEntity},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			mux.ServeHTTP(w, httptest.NewRequest(http.MethodPost, "/users/7", strings.NewReader(test.body)))
+
+			equal(t, test.status, w.Code)
+		})
+	}
+}
+
+func TestHandle_InvalidValidateTag(t *testing.T) {
+	defer func() {
+		equal(t, true, recover() != nil)
+	}()
+
+	f := httpserver.NewFormatter(coder.NewCoder("application/json", json.Marshal, json.Unmarshal))
+
+	httpserver.Handle(f, func(ctx context.Context, in struct {
+		Count int `query:"count" validate:"email"`
+	}) (any, error) {
+		return nil, nil
+	})
+}
@@ -0,0 +1,113 @@
+package httpserver
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/easysy/proton/metrics"
+)
+
+// MetricsOptions represents configuration for the Metrics middleware.
+type MetricsOptions struct {
+	// Registry receives the metrics. Defaults to metrics.DefaultRegistry.
+	Registry *metrics.Registry
+
+	// Namespace is an optional prefix of the metric names, e.g. "myapp" for "myapp_http_server_requests_total".
+	Namespace string
+
+	// Buckets are the bounds of the request duration histogram in seconds. Defaults to metrics.DefBuckets.
+	Buckets []float64
+
+	// SizeBuckets are the bounds of the response size histogram in bytes. Defaults to metrics.SizeBuckets.
+	SizeBuckets []float64
+
+	// Route returns the route label of the served request. It must return a low-cardinality value,
+	// such as a route pattern, and never the raw URL path.
+	// By default, the route matched for the request is used (see RouteFromContext).
+	Route func(r *http.Request) string
+}
+
+type serverMetrics struct {
+	requests *metrics.Counter
+	duration *metrics.Histogram
+	size     *metrics.Histogram
+	inFlight *metrics.Gauge
+	route    func(r *http.Request) string
+}
+
+func newServerMetrics(opts *MetricsOptions) *serverMetrics {
+	if opts == nil {
+		opts = &MetricsOptions{}
+	}
+
+	reg := opts.Registry
+	if reg == nil {
+		reg = metrics.DefaultRegistry
+	}
+
+	sizeBuckets := opts.SizeBuckets
+	if len(sizeBuckets) == 0 {
+		sizeBuckets = metrics.SizeBuckets
+	}
+
+	name := func(s string) string {
+		if opts.Namespace == "" {
+			return s
+		}
+		return opts.Namespace + "_" + s
+	}
+
+	m := &serverMetrics{
+		requests: reg.NewCounter(name("http_server_requests_total"),
+			"Total number of HTTP requests served.", "method", "route", "status"),
+		duration: reg.NewHistogram(name("http_server_request_duration_seconds"),
+			"Duration of HTTP requests served in seconds.", opts.Buckets, "method", "route", "status"),
+		size: reg.NewHistogram(name("http_server_response_size_bytes"),
+			"Size of HTTP response bodies in bytes.", sizeBuckets, "method", "route", "status"),
+		inFlight: reg.NewGauge(name("http_server_requests_in_flight"),
+			"Number of HTTP requests being served.", "method"),
+		route: opts.Route,
+	}
+
+	if m.route == nil {
+		m.route = func(r *http.Request) string {
+			route, _ := RouteFromContext(r.Context())
+			return route.String()
+		}
+	}
+
+	return m
+}
+
+// Metrics collects request counters, latency and response size histograms, and an in-flight gauge
+// labelled by method, route and status code. Serve the metrics with metrics.Handler, e.g. on "/metrics".
+//
+// The route label is evaluated after the request is served. Unmatched requests have an empty route.
+func Metrics(opts *MetricsOptions) func(http.Handler) http.Handler {
+	m := newServerMetrics(opts)
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			method := metrics.Method(r.Method)
+
+			m.inFlight.Inc(method)
+			rw := newResponseWriter(w)
+			r, route := withRoute(r)
+
+			defer func(start time.Time) {
+				m.inFlight.Dec(method)
+				route.capture(r)
+
+				route := m.route(r)
+				status := strconv.Itoa(rw.Status())
+
+				m.requests.Inc(method, route, status)
+				m.duration.Observe(time.Since(start).Seconds(), method, route, status)
+				m.size.Observe(float64(rw.Size()), method, route, status)
+			}(time.Now())
+
+			next.ServeHTTP(rw, r)
+		})
+	}
+}
@@ -0,0 +1,42 @@
+//go:build go1.23
+
+package httpserver_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/easysy/proton/httpserver"
+	"github.com/easysy/proton/metrics"
+)
+
+func TestMetrics(t *testing.T) {
+	reg := metrics.NewRegistry()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{id}", func(w http.ResponseWriter, _ *http.Request) {
+		_, _ = w.Write([]byte("hello"))
+	})
+
+	handler := httpserver.Metrics(&httpserver.MetricsOptions{Registry: reg})(mux)
+
+	for _, path := range []string{"/users/1", "/users/2", "/unknown"} {
+		handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, path, nil))
+	}
+
+	w := httptest.NewRecorder()
+	reg.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+	out := w.Body.String()
+
+	for _, line := range []string{
+		`http_server_requests_total{method="GET",route="GET /users/{id}",status="200"} 2`,
+		`http_server_requests_total{method="GET",route="",status="404"} 1`,
+		`http_server_request_duration_seconds_count{method="GET",route="GET /users/{id}",status="200"} 2`,
+		`http_server_response_size_bytes_sum{method="GET",route="GET /users/{id}",status="200"} 10`,
+		`http_server_requests_in_flight{method="GET"} 0`,
+	} {
+		equal(t, true, strings.Contains(out, line+"\n"))
+	}
+}
@@ -2,22 +2,16 @@ package httpserver
 
 import (
 	"context"
+	"errors"
 	"log/slog"
+	"math"
 	"net/http"
-	"net/http/httptest"
 	"runtime/debug"
 	"time"
 
 	"github.com/easysy/proton/log"
-	"github.com/easysy/proton/sgen"
 )
 
-func init() {
-	id.Configure("", sgen.UpLetters.Append(sgen.LowLetters, sgen.Nums), 12)
-}
-
-var id sgen.RandomString
-
 // MiddlewareSequencer chains middleware functions in a chain.
 func MiddlewareSequencer(baseHandler http.Handler, mws ...func(http.Handler) http.Handler) http.Handler {
 	for _, f := range mws {
@@ -26,29 +20,131 @@ func MiddlewareSequencer(baseHandler http.Handler, mws ...func(http.Handler) htt
 	return baseHandler
 }
 
+// TracerOptions represents configuration for the TracerWithOptions middleware.
+type TracerOptions struct {
+	// RequestIDHeader is a legacy request ID header, e.g. "X-Request-ID".
+	// Its value is used as the trace ID when the request has no valid "traceparent" header,
+	// and the trace ID is echoed in this header of the response. Empty disables it.
+	RequestIDHeader string
+
+	// IgnoreIncoming starts a new trace for every request, ignoring the trace context sent by the caller.
+	// Use it on the edge of a system where the callers are not trusted.
+	IgnoreIncoming bool
+}
+
+// maxRequestIDLength limits the length of a legacy request ID taken from the request.
+const maxRequestIDLength = 128
+
+var tracer = TracerWithOptions(&TracerOptions{RequestIDHeader: "X-Request-ID"})
+
 // Tracer adds trace ID to the request context.
+// It continues the trace of an incoming W3C "traceparent" (and "tracestate") or "X-Request-ID" header,
+// or starts a new one, and echoes the trace ID in the "X-Request-ID" response header.
+// The handler runs within a server span (see log.StartSpan) that is ended with the response status.
 func Tracer(next http.Handler) http.Handler {
-	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		ctx := context.WithValue(r.Context(), log.TraceCtxKey, id.Generate())
-		next.ServeHTTP(w, r.Clone(ctx))
-	})
+	return tracer(next)
+}
+
+// TracerWithOptions returns a Tracer configured by opts.
+// See Tracer and TracerOptions.
+func TracerWithOptions(opts *TracerOptions) func(http.Handler) http.Handler {
+	if opts == nil {
+		opts = &TracerOptions{}
+	}
+
+	header := opts.RequestIDHeader
+	ignore := opts.IgnoreIncoming
+
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			ctx := r.Context()
+
+			var traceID string
+
+			if !ignore {
+				if parent, ok := log.ParseTraceParent(r.Header.Get(log.TraceParentHeader)); ok {
+					traceID = parent.TraceID
+					ctx = context.WithValue(ctx, log.TraceParentCtxKey, parent)
+					if state := r.Header.Get(log.TraceStateHeader); state != "" {
+						ctx = context.WithValue(ctx, log.TraceStateCtxKey, state)
+					}
+				} else if header != "" && isRequestID(r.Header.Get(header)) {
+					traceID = r.Header.Get(header)
+				}
+			}
+
+			if traceID == "" {
+				traceID = log.NewTraceID()
+			}
+
+			if header != "" {
+				w.Header().Set(header, traceID)
+			}
+
+			ctx = log.WithMetadata(log.WithTraceID(ctx, traceID))
+
+			ctx, span := log.StartSpan(ctx, r.Method, log.SpanKindServer,
+				slog.String("http.request.method", r.Method),
+				slog.String("url.path", r.URL.Path),
+			)
+
+			rw := newResponseWriter(w)
+			req, route := withRoute(r.Clone(ctx))
+
+			defer func() {
+				route.capture(req)
+				span.SetAttributes(slog.Int("http.response.status_code", rw.Status()))
+				if rw.Status() >= http.StatusInternalServerError {
+					span.SetError(errors.New(http.StatusText(rw.Status())))
+				}
+				span.End()
+			}()
+
+			next.ServeHTTP(rw, req)
+		})
+	}
+}
+
+// isRequestID reports whether a legacy request ID is safe to use as a trace ID:
+// a non-empty string of limited length made of printable ASCII characters.
+func isRequestID(s string) bool {
+	if s == "" || len(s) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(s); i++ {
+		if s[i] <= ' ' || s[i] > '~' {
+			return false
+		}
+	}
+	return true
 }
 
 // Timer measures the time taken by http.HandlerFunc.
+// The request is logged with its route and path parameters (see Route) once matched,
+// or with its URL otherwise.
 func Timer(level slog.Level) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 			ctx := r.Context()
 			if slog.Default().Enabled(ctx, level) {
+				r, route := withRoute(r)
 				defer func(start time.Time) {
+					route.capture(r)
+
+					request := []any{slog.String("method", r.Method)}
+					if matched, ok := route.get(); ok {
+						request = append(request, routeAttrs(matched)...)
+					} else {
+						request = append(request, slog.String("url", r.RequestURI))
+					}
+
 					slog.Log(ctx, level, "finished",
-						slog.Group("request",
-							slog.String("method", r.Method),
-							slog.String("url", r.RequestURI),
-						),
+						slog.Group("request", request...),
 						slog.String("duration", time.Since(start).String()),
 					)
 				}(time.Now())
+				next.ServeHTTP(w, r)
+				return
 			}
 			next.ServeHTTP(w, r)
 		})
@@ -71,6 +167,9 @@ func PanicCatcher(next http.Handler) http.Handler {
 }
 
 // DumpHttp dumps the HTTP request and response, and prints out.
+// The response is written through to the client as the handler produces it, so streaming,
+// http.Flusher, http.Hijacker and io.ReaderFrom keep working; only the first log.MaxBodySize bytes
+// of the body are kept for the dump. The response of a hijacked connection is not dumped.
 func DumpHttp(level slog.Level, body bool) func(http.Handler) http.Handler {
 	return func(next http.Handler) http.Handler {
 		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
@@ -78,23 +177,20 @@ func DumpHttp(level slog.Level, body bool) func(http.Handler) http.Handler {
 			if slog.Default().Enabled(ctx, level) {
 				log.DumpHttpRequest(ctx, r, level, body)
 
-				recorder := httptest.NewRecorder()
-
-				next.ServeHTTP(recorder, r)
-
-				for key, values := range recorder.Header() {
-					w.Header().Del(key)
-					for _, value := range values {
-						w.Header().Set(key, value)
+				limit := 0
+				if body {
+					if limit = log.MaxBodySize(); limit == 0 {
+						limit = math.MaxInt
 					}
 				}
 
-				w.WriteHeader(recorder.Code)
+				dw := newDumpWriter(w, limit)
 
-				response := recorder.Result()
-				response.ContentLength, _ = recorder.Body.WriteTo(w)
+				next.ServeHTTP(dw, r)
 
-				log.DumpHttpResponse(ctx, response, level, body)
+				if !dw.hijacked {
+					log.DumpHttpResponse(ctx, dw.Response(r), level, body)
+				}
 
 				return
 			}
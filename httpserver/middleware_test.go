@@ -0,0 +1,243 @@
+package httpserver_test
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"io"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/easysy/proton/httpserver"
+	"github.com/easysy/proton/log"
+)
+
+func TestDumpHttp_Streaming(t *testing.T) {
+	level := slog.SetLogLoggerLevel(slog.LevelDebug)
+	defer slog.SetLogLoggerLevel(level)
+
+	proceed := make(chan struct{})
+
+	srv := httptest.NewServer(httpserver.DumpHttp(slog.LevelDebug, true)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, ok := w.(http.Flusher)
+		equal(t, true, ok)
+		_, ok = w.(http.Hijacker)
+		equal(t, true, ok)
+
+		w.Header().Set("Content-Type", "text/event-stream")
+		w.Header().Add("X-Multi", "a")
+		w.Header().Add("X-Multi", "b")
+
+		_, _ = w.Write([]byte("data: first\n\n"))
+		equal(t, nil, http.NewResponseController(w).Flush())
+
+		// The client must receive the first event before the handler returns.
+		<-proceed
+
+		_, _ = w.Write([]byte("data: second\n\n"))
+	})))
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL)
+	equal(t, nil, err)
+
+	defer func() { _ = resp.Body.Close() }()
+
+	equal(t, []string{"a", "b"}, resp.Header.Values("X-Multi"))
+
+	reader := bufio.NewReader(resp.Body)
+
+	line, err := reader.ReadString('\n')
+	equal(t, nil, err)
+	equal(t, "data: first\n", line)
+
+	close(proceed)
+
+	_, _ = reader.ReadString('\n')
+	line, err = reader.ReadString('\n')
+	equal(t, nil, err)
+	equal(t, "data: second\n", line)
+}
+
+func TestDumpHttp_Redaction(t *testing.T) {
+	out := new(bytes.Buffer)
+
+	logger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewTextHandler(out, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	defer slog.SetDefault(logger)
+
+	log.SetRedaction(log.Redaction{
+		Headers: []string{"authorization", "Set-Cookie"},
+		Query:   []string{"token"},
+		Fields:  []string{"password", "items.secret"},
+	})
+	defer log.SetRedaction(log.DefaultRedaction)
+
+	handler := httpserver.DumpHttp(slog.LevelDebug, true)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		equal(t, "Bearer secret-token", r.Header.Get("Authorization"))
+		equal(t, "abc", r.URL.Query().Get("token"))
+
+		b, err := io.ReadAll(r.Body)
+		equal(t, nil, err)
+		equal(t, `{"user":"bob","password":"p4ss","items":[{"secret":1,"id":2}]}`, string(b))
+
+		http.SetCookie(w, &http.Cookie{Name: "session", Value: "cookie-value"})
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"password":"p4ss"}`))
+	}))
+
+	body := `{"user":"bob","password":"p4ss","items":[{"secret":1,"id":2}]}`
+
+	r := httptest.NewRequest(http.MethodPost, "/login?token=abc&page=1", strings.NewReader(body))
+	r.Header.Set("Authorization", "Bearer secret-token")
+	r.Header.Set("Content-Type", "application/json")
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, r)
+
+	equal(t, `{"password":"p4ss"}`, w.Body.String())
+	equal(t, "session=cookie-value", w.Header().Get("Set-Cookie"))
+
+	dump := out.String()
+	for _, secret := range []string{"secret-token", "token=abc", "p4ss", `\"secret\":1`, "cookie-value"} {
+		equal(t, false, strings.Contains(dump, secret))
+	}
+	equal(t, true, strings.Contains(dump, "page=1"))
+	equal(t, true, strings.Contains(dump, `\"user\":\"bob\"`))
+}
+
+func TestDumpHttp_Structured(t *testing.T) {
+	out := new(bytes.Buffer)
+
+	logger := slog.Default()
+	slog.SetDefault(slog.New(slog.NewJSONHandler(out, &slog.HandlerOptions{Level: slog.LevelDebug})))
+	defer slog.SetDefault(logger)
+
+	log.SetDumpFormat(log.DumpStructured)
+	defer log.SetDumpFormat(log.DumpRaw)
+
+	handler := httpserver.DumpHttp(slog.LevelDebug, true)(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusAccepted)
+		_, _ = w.Write([]byte(`{"id":7}`))
+	}))
+
+	r := httptest.NewRequest(http.MethodPost, "/users?page=1", strings.NewReader(`{"name":"bob"}`))
+	r.Header.Set("Content-Type", "application/json")
+
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	dec := json.NewDecoder(out)
+
+	var req struct {
+		Request struct {
+			Method  string            `json:"method"`
+			URL     string            `json:"url"`
+			Headers map[string]string `json:"headers"`
+			Body    map[string]any    `json:"body"`
+		} `json:"request"`
+	}
+	equal(t, nil, dec.Decode(&req))
+	equal(t, http.MethodPost, req.Request.Method)
+	equal(t, "/users?page=1", req.Request.URL)
+	equal(t, "application/json", req.Request.Headers["Content-Type"])
+	equal(t, map[string]any{"name": "bob"}, req.Request.Body)
+
+	var resp struct {
+		Response struct {
+			Status int            `json:"status"`
+			Body   map[string]any `json:"body"`
+		} `json:"response"`
+	}
+	equal(t, nil, dec.Decode(&resp))
+	equal(t, http.StatusAccepted, resp.Response.Status)
+	equal(t, map[string]any{"id": float64(7)}, resp.Response.Body)
+}
+
+func TestTracer(t *testing.T) {
+	const traceID = "4bf92f3577b34da6a3ce929d0e0e4736"
+
+	var tests = []struct {
+		name    string
+		headers map[string]string
+		traceID string
+	}{
+		{
+			name:    "traceparent",
+			headers: map[string]string{"Traceparent": "00-" + traceID + "-00f067aa0ba902b7-01", "X-Request-ID": "legacy"},
+			traceID: traceID,
+		},
+		{
+			name:    "legacy request ID",
+			headers: map[string]string{"X-Request-ID": "legacy"},
+			traceID: "legacy",
+		},
+		{
+			name:    "invalid traceparent",
+			headers: map[string]string{"Traceparent": "00-" + traceID + "-0000000000000000-01"},
+		},
+		{
+			name: "new trace",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			var got string
+
+			handler := httpserver.Tracer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				got, _ = r.Context().Value(log.TraceCtxKey).(string)
+			}))
+
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			for key, value := range test.headers {
+				r.Header.Set(key, value)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if test.traceID != "" {
+				equal(t, test.traceID, got)
+			} else {
+				equal(t, true, log.IsTraceID(got))
+			}
+			equal(t, got, w.Header().Get("X-Request-ID"))
+		})
+	}
+}
+
+func TestTracer_Metadata(t *testing.T) {
+	out := new(bytes.Buffer)
+
+	logger := slog.Default()
+	slog.SetDefault(slog.New(log.TraceHandler{Handler: slog.NewJSONHandler(out, nil), Metadata: true}))
+	defer slog.SetDefault(logger)
+
+	var traceID, spanID string
+
+	handler := httpserver.Tracer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+
+		traceID = log.TraceID(ctx)
+		spanID = log.SpanFromContext(ctx).SpanID
+
+		log.SetMetadata(ctx, log.MetaUserID, "u1")
+		log.SetMetadata(ctx, log.MetaTenant, "acme")
+
+		slog.With("component", "test").InfoContext(ctx, "hello")
+	}))
+
+	handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	record := make(map[string]any)
+	equal(t, nil, json.Unmarshal(out.Bytes(), &record))
+	equal(t, traceID, record["trace_id"])
+	equal(t, spanID, record["span_id"])
+	equal(t, "u1", record["user_id"])
+	equal(t, "acme", record["tenant"])
+	equal(t, "test", record["component"])
+}
@@ -0,0 +1,11 @@
+//go:build !go1.23
+
+package httpserver
+
+import "net/http"
+
+// requestPattern returns the pattern matched for r by http.ServeMux, which is not exposed before Go 1.23,
+// so only routes registered with Named are recorded.
+func requestPattern(_ *http.Request) string {
+	return ""
+}
@@ -0,0 +1,10 @@
+//go:build go1.23
+
+package httpserver
+
+import "net/http"
+
+// requestPattern returns the pattern matched for r by http.ServeMux.
+func requestPattern(r *http.Request) string {
+	return r.Pattern
+}
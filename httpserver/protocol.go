@@ -0,0 +1,62 @@
+package httpserver
+
+import (
+	"errors"
+	"net/http"
+)
+
+var (
+	ErrProtocolNeedsTLS    = errors.New("HTTP/2 over TLS requires the server TLS configuration")
+	ErrProtocolNoTLS       = errors.New("HTTP/2 cleartext (h2c) cannot be used with the server TLS configuration")
+	ErrProtocolUnsupported = errors.New("HTTP/2 cleartext (h2c) requires Go 1.24 or later")
+)
+
+// Protocol selects the HTTP protocols served by the Controller.
+type Protocol int
+
+const (
+	// ProtocolDefault keeps the protocols configured on *http.Server:
+	// HTTP/1.1, plus HTTP/2 when the server uses TLS.
+	ProtocolDefault Protocol = iota
+	// ProtocolHTTP1 serves HTTP/1.1 only, with or without TLS.
+	ProtocolHTTP1
+	// ProtocolHTTP2 serves HTTP/2 over TLS with a fallback to HTTP/1.1.
+	// The server must have a TLS configuration.
+	ProtocolHTTP2
+	// ProtocolH2C serves HTTP/2 cleartext (with prior knowledge) and HTTP/1.1 without TLS.
+	// It is intended for internal traffic, such as service meshes terminating TLS in a sidecar.
+	// It requires Go 1.24 or later.
+	ProtocolH2C
+)
+
+func (p Protocol) String() string {
+	switch p {
+	case ProtocolDefault:
+		return "default"
+	case ProtocolHTTP1:
+		return "HTTP/1.1"
+	case ProtocolHTTP2:
+		return "HTTP/2"
+	case ProtocolH2C:
+		return "h2c"
+	default:
+		return "unknown"
+	}
+}
+
+// validate reports whether p can be served by srv.
+func (p Protocol) validate(srv *http.Server) error {
+	secure := srv.TLSConfig != nil
+
+	switch p {
+	case ProtocolHTTP2:
+		if !secure {
+			return ErrProtocolNeedsTLS
+		}
+	case ProtocolH2C:
+		if secure {
+			return ErrProtocolNoTLS
+		}
+	}
+	return nil
+}
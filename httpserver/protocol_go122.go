@@ -0,0 +1,32 @@
+//go:build !go1.24
+
+package httpserver
+
+import (
+	"crypto/tls"
+	"net/http"
+)
+
+// HTTP2Config holds the HTTP/2 settings of the server. It has no settings before Go 1.24,
+// where *http.Server cannot be configured for HTTP/2.
+type HTTP2Config struct{}
+
+// apply configures the protocols of srv. HTTP/1.1 only is served by disabling the upgrade to HTTP/2;
+// cfg is ignored.
+func (p Protocol) apply(srv *http.Server, _ *HTTP2Config) error {
+	if err := p.validate(srv); err != nil {
+		return err
+	}
+
+	switch p {
+	case ProtocolHTTP1:
+		srv.TLSNextProto = make(map[string]func(*http.Server, *tls.Conn, http.Handler))
+	case ProtocolH2C:
+		return ErrProtocolUnsupported
+	}
+
+	return nil
+}
+
+// cloneProtocols copies the protocol settings of src to dst; there are none before Go 1.24.
+func cloneProtocols(_, _ *http.Server) {}
@@ -0,0 +1,46 @@
+//go:build go1.24
+
+package httpserver
+
+import "net/http"
+
+// HTTP2Config holds the HTTP/2 settings of the server, see http.HTTP2Config.
+type HTTP2Config = http.HTTP2Config
+
+// apply configures the protocols of srv.
+// The HTTP/2 settings are applied only for ProtocolHTTP2 and ProtocolH2C, and only when cfg is non nil,
+// so settings made on srv directly are preserved.
+func (p Protocol) apply(srv *http.Server, cfg *HTTP2Config) error {
+	if err := p.validate(srv); err != nil {
+		return err
+	}
+
+	protocols := new(http.Protocols)
+
+	switch p {
+	case ProtocolHTTP1:
+		protocols.SetHTTP1(true)
+	case ProtocolHTTP2:
+		protocols.SetHTTP1(true)
+		protocols.SetHTTP2(true)
+	case ProtocolH2C:
+		protocols.SetHTTP1(true)
+		protocols.SetUnencryptedHTTP2(true)
+	default:
+		return nil
+	}
+
+	srv.Protocols = protocols
+
+	if cfg != nil && p != ProtocolHTTP1 {
+		srv.HTTP2 = cfg
+	}
+
+	return nil
+}
+
+// cloneProtocols copies the protocol settings of src to dst.
+func cloneProtocols(dst, src *http.Server) {
+	dst.HTTP2 = src.HTTP2
+	dst.Protocols = src.Protocols
+}
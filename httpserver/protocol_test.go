@@ -0,0 +1,82 @@
+//go:build go1.24
+
+package httpserver_test
+
+import (
+	"io"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/easysy/proton/httpserver"
+)
+
+func TestController_ProtocolH2C(t *testing.T) {
+	srv := &http.Server{
+		Addr: freeAddr(t),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, _ = io.WriteString(w, r.Proto)
+		}),
+	}
+
+	hcr := &httpserver.Controller{Server: srv, GracefulTimeout: time.Second, Protocol: httpserver.ProtocolH2C}
+
+	started := make(chan struct{})
+	hcr.OnStart(func(*http.Server) { close(started) })
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- hcr.Start() }()
+
+	<-started
+
+	protocols := new(http.Protocols)
+	protocols.SetUnencryptedHTTP2(true)
+
+	clt := &http.Client{Transport: &http.Transport{Protocols: protocols}}
+
+	var (
+		resp *http.Response
+		err  error
+	)
+	for i := 0; i < 50; i++ {
+		if resp, err = clt.Get("http://" + srv.Addr); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	equal(t, nil, err)
+
+	b, err := io.ReadAll(resp.Body)
+	equal(t, nil, err)
+	equal(t, nil, resp.Body.Close())
+	equal(t, "HTTP/2.0", string(b))
+
+	hcr.Shutdown()
+	equal(t, nil, <-stopped)
+}
+
+func TestController_ProtocolHTTP1KeepsHTTP2(t *testing.T) {
+	srv := &http.Server{Addr: freeAddr(t)}
+
+	hcr := &httpserver.Controller{
+		Server:          srv,
+		GracefulTimeout: time.Second,
+		Protocol:        httpserver.ProtocolHTTP1,
+		HTTP2:           &httpserver.HTTP2Config{MaxConcurrentStreams: 10},
+	}
+
+	started := make(chan struct{})
+	hcr.OnStart(func(*http.Server) { close(started) })
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- hcr.Start() }()
+
+	<-started
+
+	hcr.Shutdown()
+	equal(t, nil, <-stopped)
+
+	equal(t, (*http.HTTP2Config)(nil), srv.HTTP2)
+	equal(t, true, srv.Protocols.HTTP1())
+	equal(t, false, srv.Protocols.HTTP2())
+}
@@ -0,0 +1,169 @@
+package httpserver
+
+import (
+	"context"
+	"log/slog"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+
+	"github.com/easysy/proton/log"
+)
+
+// Route describes the route that matched a request.
+type Route struct {
+	// Pattern is the pattern matched by http.ServeMux, e.g. "GET /users/{id}". It is empty before Go 1.23.
+	Pattern string
+
+	// Name is the name registered for the route with Named, if any.
+	Name string
+
+	// Params holds the values of the pattern wildcards, e.g. {"id": "42"}.
+	Params map[string]string
+}
+
+// String returns the name of the route, if any, or its pattern.
+func (r Route) String() string {
+	if r.Name != "" {
+		return r.Name
+	}
+	return r.Pattern
+}
+
+type routeCtxKey struct{}
+
+// routeHolder is shared by all requests derived from the one it was attached to,
+// so the route matched by the mux is visible to the outer middlewares.
+type routeHolder struct {
+	mu    sync.Mutex
+	route Route
+	set   bool
+	done  bool
+}
+
+// RouteFromContext returns the route matched for the request of ctx.
+// A route registered with Named is known within its handler; any other route is recorded by
+// the route-aware middlewares (Tracer, Timer, AccessLog, Metrics) after the handler returns.
+// At least one of them must be in the chain with no request-replacing middleware between it and the mux.
+func RouteFromContext(ctx context.Context) (Route, bool) {
+	h, _ := ctx.Value(routeCtxKey{}).(*routeHolder)
+	if h == nil {
+		return Route{}, false
+	}
+	return h.get()
+}
+
+// withRoute returns r with a routeHolder attached to its context, or r itself if it already has one.
+func withRoute(r *http.Request) (*http.Request, *routeHolder) {
+	if h, _ := r.Context().Value(routeCtxKey{}).(*routeHolder); h != nil {
+		return r, h
+	}
+	h := new(routeHolder)
+	return r.WithContext(context.WithValue(r.Context(), routeCtxKey{}, h)), h
+}
+
+func (h *routeHolder) get() (Route, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.route, h.set
+}
+
+// capture records the route matched for r by http.ServeMux, which sets the pattern on the request it serves.
+// It must be called with the request passed to the next handler, after the handler returns.
+// The first recorded route wins, so the route recorded by Named is kept.
+func (h *routeHolder) capture(r *http.Request) {
+	if requestPattern(r) == "" {
+		return
+	}
+	h.record(r, "")
+}
+
+// unmatched marks the request as matching no route, so that no route is recorded for it.
+func (h *routeHolder) unmatched() {
+	h.mu.Lock()
+	h.done = true
+	h.mu.Unlock()
+}
+
+func (h *routeHolder) record(r *http.Request, name string) {
+	h.mu.Lock()
+	if h.set || h.done {
+		h.mu.Unlock()
+		return
+	}
+	h.route = Route{Pattern: requestPattern(r), Name: name, Params: pathParams(r)}
+	h.set = true
+	route := h.route
+	h.mu.Unlock()
+
+	ctx := r.Context()
+
+	if md := log.MetadataFromContext(ctx); md != nil {
+		md.Set(log.MetaRoute, route.String())
+	}
+
+	if span := log.SpanFromContext(ctx); span != nil {
+		span.SetName(route.String())
+		span.SetAttributes(slog.String("http.route", route.Pattern))
+	}
+}
+
+// pathParams returns the values of the wildcards of the pattern matched for r.
+func pathParams(r *http.Request) map[string]string {
+	var params map[string]string
+
+	pattern := requestPattern(r)
+	for {
+		start := strings.IndexByte(pattern, '{')
+		if start < 0 {
+			return params
+		}
+		end := strings.IndexByte(pattern[start:], '}')
+		if end < 0 {
+			return params
+		}
+
+		name := strings.TrimSuffix(pattern[start+1:start+end], "...")
+		pattern = pattern[start+end+1:]
+
+		if name == "" || name == "$" {
+			continue
+		}
+		if params == nil {
+			params = make(map[string]string)
+		}
+		params[name] = r.PathValue(name)
+	}
+}
+
+// Named wraps a handler registered on http.ServeMux, recording the route under the name before the handler runs,
+// so the name is also available to the handler itself and its logs (as log.MetaRoute). For example:
+//
+//	mux.Handle("GET /users/{id}", httpserver.Named("get-user", handler))
+func Named(name string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		r, h := withRoute(r)
+		h.record(r, name)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// routeAttrs returns the log attributes of the route: the route itself and its path parameters.
+func routeAttrs(route Route) []any {
+	attrs := []any{slog.String("route", route.String())}
+	if len(route.Params) > 0 {
+		params := make([]any, 0, len(route.Params))
+		names := make([]string, 0, len(route.Params))
+		for name := range route.Params {
+			names = append(names, name)
+		}
+		slices.Sort(names)
+
+		for _, name := range names {
+			params = append(params, slog.String(name, route.Params[name]))
+		}
+		attrs = append(attrs, slog.Group("params", params...))
+	}
+	return attrs
+}
@@ -0,0 +1,97 @@
+//go:build go1.23
+
+package httpserver_test
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/easysy/proton/httpserver"
+	"github.com/easysy/proton/log"
+)
+
+func TestRoute(t *testing.T) {
+	out := new(bytes.Buffer)
+
+	logger := slog.Default()
+	slog.SetDefault(slog.New(log.TraceHandler{Handler: slog.NewJSONHandler(out, nil), Metadata: true}))
+	defer slog.SetDefault(logger)
+
+	spans := new(bytes.Buffer)
+	log.SetSpanExporter(log.NewWriterExporter(spans))
+	defer log.SetSpanExporter(nil)
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /users/{id}", func(w http.ResponseWriter, r *http.Request) {
+		route, ok := httpserver.RouteFromContext(r.Context())
+		equal(t, false, ok)
+		equal(t, httpserver.Route{}, route)
+	})
+	mux.Handle("GET /files/{path...}", httpserver.Named("file", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		route, ok := httpserver.RouteFromContext(r.Context())
+		equal(t, true, ok)
+		equal(t, "file", route.String())
+		slog.InfoContext(r.Context(), "serving")
+	})))
+
+	handler := httpserver.MiddlewareSequencer(mux,
+		httpserver.Tracer,
+		httpserver.Timer(slog.LevelInfo),
+	)
+
+	var tests = []struct {
+		name   string
+		path   string
+		route  string
+		params map[string]any
+	}{
+		{name: "pattern", path: "/users/42", route: "GET /users/{id}", params: map[string]any{"id": "42"}},
+		{name: "named", path: "/files/a/b", route: "file", params: map[string]any{"path": "a/b"}},
+		{name: "unmatched", path: "/unknown"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			out.Reset()
+			spans.Reset()
+
+			handler.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, test.path, nil))
+
+			var finished map[string]any
+			scanner := bufio.NewScanner(out)
+			for scanner.Scan() {
+				record := make(map[string]any)
+				equal(t, nil, json.Unmarshal(scanner.Bytes(), &record))
+				if record["msg"] == "serving" {
+					equal(t, test.route, record["route"])
+				}
+				if record["msg"] == "finished" {
+					finished = record["request"].(map[string]any)
+				}
+			}
+
+			span := make(map[string]any)
+			equal(t, nil, json.Unmarshal(spans.Bytes(), &span))
+
+			if test.route == "" {
+				equal(t, test.path, finished["url"])
+				equal(t, http.MethodGet, span["name"])
+				return
+			}
+
+			equal(t, test.route, finished["route"])
+			equal(t, test.params, finished["params"])
+			equal(t, test.route, span["name"])
+		})
+	}
+
+	route, ok := httpserver.RouteFromContext(context.Background())
+	equal(t, false, ok)
+	equal(t, "", route.String())
+}
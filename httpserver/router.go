@@ -0,0 +1,380 @@
+package httpserver
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// ErrRouteNotFound is returned by Router.URL for a name that is not registered.
+var ErrRouteNotFound = errors.New("route not found")
+
+// Router is a request router built on http.ServeMux patterns.
+// It adds route groups with path prefixes, per-group and per-route middleware chains,
+// named routes with URL reversal, and custom 404 and 405 responses.
+// A request matching a path registered only for other methods is answered with
+// 405 Method Not Allowed and the "Allow" header listing the registered methods.
+//
+// Middlewares are chained as by MiddlewareSequencer: the first one is the innermost.
+// The middlewares of the root router wrap the whole mux, so they also see unmatched requests;
+// the middlewares of a group wrap every route registered in the group after the call to Use.
+type Router struct {
+	state  *routerState
+	parent *Router
+	prefix string
+	mws    []func(http.Handler) http.Handler
+}
+
+// routerState is shared by a router and its groups.
+type routerState struct {
+	mux *http.ServeMux
+
+	mu               sync.RWMutex
+	names            map[string]*Endpoint
+	methods          []string
+	notFound         http.Handler
+	methodNotAllowed http.Handler
+
+	once    sync.Once
+	handler http.Handler
+	served  bool
+}
+
+// NewRouter returns an empty Router.
+func NewRouter() *Router {
+	rt := &Router{
+		state: &routerState{
+			mux:              http.NewServeMux(),
+			names:            make(map[string]*Endpoint),
+			notFound:         http.NotFoundHandler(),
+			methodNotAllowed: http.HandlerFunc(methodNotAllowed),
+		},
+	}
+
+	// The catch-all pattern receives every unmatched request, so that the router
+	// distinguishes 404 from 405 itself. It keeps the trailing slash redirects of ServeMux.
+	rt.state.mux.HandleFunc("/", rt.state.fallback)
+
+	return rt
+}
+
+func methodNotAllowed(w http.ResponseWriter, _ *http.Request) {
+	http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+}
+
+// NotFound sets the handler of requests matching no route. Defaults to http.NotFoundHandler.
+func (rt *Router) NotFound(h http.Handler) {
+	rt.state.mu.Lock()
+	rt.state.notFound = h
+	rt.state.mu.Unlock()
+}
+
+// MethodNotAllowed sets the handler of requests matching a route registered only for other methods.
+// The "Allow" header is set before the handler is called.
+func (rt *Router) MethodNotAllowed(h http.Handler) {
+	rt.state.mu.Lock()
+	rt.state.methodNotAllowed = h
+	rt.state.mu.Unlock()
+}
+
+// Use appends middlewares to the router.
+// The middlewares of the root router are chained when it serves the first request;
+// Use panics if it is called on the root router after that.
+func (rt *Router) Use(mws ...func(http.Handler) http.Handler) {
+	if rt.parent == nil {
+		rt.state.mu.RLock()
+		served := rt.state.served
+		rt.state.mu.RUnlock()
+
+		if served {
+			panic("httpserver: Use called on the root router after it served a request")
+		}
+	}
+
+	rt.mws = append(rt.mws, mws...)
+}
+
+// Group returns a router registering its routes under the path prefix, e.g. "/api/v1",
+// with the middlewares of this router (if it is a group) followed by mws.
+// It panics if the prefix is not empty and does not start with a slash.
+func (rt *Router) Group(prefix string, mws ...func(http.Handler) http.Handler) *Router {
+	if prefix != "" && prefix[0] != '/' {
+		panic(fmt.Sprintf("httpserver: group prefix %q does not start with a slash", prefix))
+	}
+
+	return &Router{
+		state:  rt.state,
+		parent: rt,
+		prefix: rt.prefix + strings.TrimSuffix(prefix, "/"),
+		mws:    slices.Clone(mws),
+	}
+}
+
+// Route calls fn with a group under the prefix. It is a convenience for declaring nested groups.
+func (rt *Router) Route(prefix string, fn func(g *Router), mws ...func(http.Handler) http.Handler) {
+	fn(rt.Group(prefix, mws...))
+}
+
+// Endpoint is a route registered on a Router.
+type Endpoint struct {
+	pattern string
+	path    string
+	handler http.Handler
+	state   *routerState
+
+	mu   sync.RWMutex
+	name string
+}
+
+// Name names the route for URL reversal (see Router.URL) and for logs and metrics (see Route).
+// It panics if the name is already used by another route of the router.
+func (e *Endpoint) Name(name string) *Endpoint {
+	e.state.mu.Lock()
+	if other, ok := e.state.names[name]; ok && other != e {
+		e.state.mu.Unlock()
+		panic(fmt.Sprintf("httpserver: route name %q is already used by %q", name, other.pattern))
+	}
+	e.state.names[name] = e
+
+	e.mu.Lock()
+	if e.name != "" && e.name != name {
+		delete(e.state.names, e.name)
+	}
+	e.name = name
+	e.mu.Unlock()
+
+	e.state.mu.Unlock()
+	return e
+}
+
+// Pattern returns the full http.ServeMux pattern of the route, including the group prefixes.
+func (e *Endpoint) Pattern() string {
+	return e.pattern
+}
+
+// ServeHTTP records the route name, if any, and calls the handler of the route.
+func (e *Endpoint) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	e.mu.RLock()
+	name := e.name
+	e.mu.RUnlock()
+
+	if name != "" {
+		var h *routeHolder
+		r, h = withRoute(r)
+		h.record(r, name)
+	}
+
+	e.handler.ServeHTTP(w, r)
+}
+
+// Handle registers the handler for the pattern, which has the http.ServeMux syntax "[METHOD ][HOST]/[PATH]".
+// The path is prefixed with the group prefixes. The handler is wrapped in mws, then in the middlewares
+// of the enclosing groups. Registering the method-less root pattern "/" sets the NotFound handler instead.
+// Like http.ServeMux.Handle, it panics on an invalid or conflicting pattern.
+func (rt *Router) Handle(pattern string, h http.Handler, mws ...func(http.Handler) http.Handler) *Endpoint {
+	method, host, path := splitPattern(pattern)
+
+	path = rt.prefix + path
+	if path == "" {
+		path = "/"
+	}
+
+	h = MiddlewareSequencer(h, mws...)
+	for g := rt; g.parent != nil; g = g.parent {
+		h = MiddlewareSequencer(h, g.mws...)
+	}
+
+	if method == "" && host == "" && path == "/" {
+		rt.NotFound(h)
+		return &Endpoint{pattern: path, path: path, handler: h, state: rt.state}
+	}
+
+	e := &Endpoint{pattern: host + path, path: path, handler: h, state: rt.state}
+	if method != "" {
+		e.pattern = method + " " + e.pattern
+	}
+
+	rt.state.mux.Handle(e.pattern, e)
+
+	rt.state.mu.Lock()
+	if method != "" && !slices.Contains(rt.state.methods, method) {
+		rt.state.methods = append(rt.state.methods, method)
+		if method == http.MethodGet && !slices.Contains(rt.state.methods, http.MethodHead) {
+			rt.state.methods = append(rt.state.methods, http.MethodHead)
+		}
+	}
+	rt.state.mu.Unlock()
+
+	return e
+}
+
+// HandleFunc registers the handler function for the pattern. See Handle.
+func (rt *Router) HandleFunc(pattern string, h http.HandlerFunc, mws ...func(http.Handler) http.Handler) *Endpoint {
+	return rt.Handle(pattern, h, mws...)
+}
+
+// Get registers the handler function for GET (and HEAD) requests of the path. See Handle.
+func (rt *Router) Get(path string, h http.HandlerFunc, mws ...func(http.Handler) http.Handler) *Endpoint {
+	return rt.Handle(http.MethodGet+" "+path, h, mws...)
+}
+
+// Post registers the handler function for POST requests of the path. See Handle.
+func (rt *Router) Post(path string, h http.HandlerFunc, mws ...func(http.Handler) http.Handler) *Endpoint {
+	return rt.Handle(http.MethodPost+" "+path, h, mws...)
+}
+
+// Put registers the handler function for PUT requests of the path. See Handle.
+func (rt *Router) Put(path string, h http.HandlerFunc, mws ...func(http.Handler) http.Handler) *Endpoint {
+	return rt.Handle(http.MethodPut+" "+path, h, mws...)
+}
+
+// Patch registers the handler function for PATCH requests of the path. See Handle.
+func (rt *Router) Patch(path string, h http.HandlerFunc, mws ...func(http.Handler) http.Handler) *Endpoint {
+	return rt.Handle(http.MethodPatch+" "+path, h, mws...)
+}
+
+// Delete registers the handler function for DELETE requests of the path. See Handle.
+func (rt *Router) Delete(path string, h http.HandlerFunc, mws ...func(http.Handler) http.Handler) *Endpoint {
+	return rt.Handle(http.MethodDelete+" "+path, h, mws...)
+}
+
+// splitPattern splits a http.ServeMux pattern into the method, the host and the path.
+func splitPattern(pattern string) (method, host, path string) {
+	pattern = strings.TrimLeft(pattern, " \t")
+	if i := strings.IndexAny(pattern, " \t"); i >= 0 {
+		method, pattern = pattern[:i], strings.TrimLeft(pattern[i+1:], " \t")
+	}
+	if i := strings.IndexByte(pattern, '/'); i > 0 {
+		host, pattern = pattern[:i], pattern[i:]
+	} else if i < 0 {
+		host, pattern = pattern, ""
+	}
+	return method, host, pattern
+}
+
+// ServeHTTP dispatches the request to the handler of the matching route, wrapped in the router middlewares.
+func (rt *Router) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	root := rt
+	for root.parent != nil {
+		root = root.parent
+	}
+
+	rt.state.once.Do(func() {
+		rt.state.mu.Lock()
+		rt.state.served = true
+		rt.state.mu.Unlock()
+
+		rt.state.handler = MiddlewareSequencer(rt.state.mux, root.mws...)
+	})
+
+	rt.state.handler.ServeHTTP(w, r)
+}
+
+// fallback serves requests matching no registered pattern.
+func (s *routerState) fallback(w http.ResponseWriter, r *http.Request) {
+	// the catch-all pattern is not a route
+	_, route := withRoute(r)
+	route.unmatched()
+
+	s.mu.RLock()
+	notFound, notAllowed := s.notFound, s.methodNotAllowed
+	methods := slices.Clone(s.methods)
+	s.mu.RUnlock()
+
+	var allow []string
+	for _, method := range methods {
+		if method == r.Method {
+			continue
+		}
+		probe := r.WithContext(r.Context())
+		probe.Method = method
+		// Only a route counts: the catch-all pattern and the trailing slash redirects of ServeMux do not.
+		if h, _ := s.mux.Handler(probe); isEndpoint(h) {
+			allow = append(allow, method)
+		}
+	}
+
+	if len(allow) == 0 {
+		notFound.ServeHTTP(w, r)
+		return
+	}
+
+	slices.Sort(allow)
+	w.Header().Set("Allow", strings.Join(allow, ", "))
+	notAllowed.ServeHTTP(w, r)
+}
+
+func isEndpoint(h http.Handler) bool {
+	_, ok := h.(*Endpoint)
+	return ok
+}
+
+// URL returns the path of the named route with the wildcards replaced by the params,
+// given as name and value pairs, e.g. URL("get-user", "id", "42").
+// Values are escaped; the value of a "{name...}" wildcard may contain slashes.
+func (rt *Router) URL(name string, params ...string) (string, error) {
+	if len(params)%2 != 0 {
+		return "", fmt.Errorf("route %q: odd number of params", name)
+	}
+
+	rt.state.mu.RLock()
+	e, ok := rt.state.names[name]
+	rt.state.mu.RUnlock()
+
+	if !ok {
+		return "", fmt.Errorf("%w: %q", ErrRouteNotFound, name)
+	}
+
+	values := make(map[string]string, len(params)/2)
+	for i := 0; i < len(params); i += 2 {
+		values[params[i]] = params[i+1]
+	}
+
+	var b strings.Builder
+
+	path := e.path
+	for {
+		start := strings.IndexByte(path, '{')
+		if start < 0 {
+			b.WriteString(path)
+			break
+		}
+		end := strings.IndexByte(path[start:], '}')
+		if end < 0 {
+			b.WriteString(path)
+			break
+		}
+
+		b.WriteString(path[:start])
+		wildcard := path[start+1 : start+end]
+		path = path[start+end+1:]
+
+		if wildcard == "$" {
+			continue
+		}
+
+		key, rest := strings.CutSuffix(wildcard, "...")
+
+		value, ok := values[key]
+		if !ok {
+			return "", fmt.Errorf("route %q: missing param %q", name, key)
+		}
+
+		if !rest {
+			b.WriteString(url.PathEscape(value))
+			continue
+		}
+
+		segments := strings.Split(value, "/")
+		for i := range segments {
+			segments[i] = url.PathEscape(segments[i])
+		}
+		b.WriteString(strings.Join(segments, "/"))
+	}
+
+	return b.String(), nil
+}
@@ -0,0 +1,158 @@
+package httpserver_test
+
+import (
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/easysy/proton/httpserver"
+)
+
+// tag returns a middleware appending the tag to the "X-Chain" response header.
+func tag(tag string) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Add("X-Chain", tag)
+			next.ServeHTTP(w, r)
+		})
+	}
+}
+
+func TestRouter(t *testing.T) {
+	rt := httpserver.NewRouter()
+	rt.Use(tag("root"))
+
+	write := func(body string) http.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request) {
+			route, _ := httpserver.RouteFromContext(r.Context())
+			_, _ = io.WriteString(w, body+" "+route.Name+" "+r.PathValue("id"))
+		}
+	}
+
+	api := rt.Group("/api", tag("api"))
+	api.Get("/users/{id}", write("get"), tag("route")).Name("get-user")
+	api.Delete("/users/{id}", write("delete"))
+	api.Get("/docs/", write("docs"))
+
+	api.Route("/admin", func(g *httpserver.Router) {
+		g.Post("/jobs", write("job"))
+	}, tag("admin"))
+
+	rt.Get("/files/{path...}", write("file")).Name("file")
+
+	var tests = []struct {
+		name   string
+		method string
+		path   string
+		status int
+		body   string
+		chain  []string
+		allow  string
+	}{
+		{
+			name:   "named route in group",
+			method: http.MethodGet,
+			path:   "/api/users/42",
+			status: http.StatusOK,
+			body:   "get get-user 42",
+			chain:  []string{"root", "api", "route"},
+		},
+		{
+			name:   "nested group",
+			method: http.MethodPost,
+			path:   "/api/admin/jobs",
+			status: http.StatusOK,
+			body:   "job  ",
+			chain:  []string{"root", "api", "admin"},
+		},
+		{
+			name:   "method not allowed",
+			method: http.MethodPut,
+			path:   "/api/users/42",
+			status: http.StatusMethodNotAllowed,
+			chain:  []string{"root"},
+			allow:  "DELETE, GET, HEAD",
+		},
+		{
+			name:   "only a trailing slash redirect for other methods",
+			method: http.MethodPost,
+			path:   "/api/docs",
+			status: http.StatusNotFound,
+			chain:  []string{"root"},
+		},
+		{
+			name:   "not found",
+			method: http.MethodGet,
+			path:   "/api/unknown",
+			status: http.StatusNotFound,
+			chain:  []string{"root"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			rt.ServeHTTP(w, httptest.NewRequest(test.method, test.path, nil))
+
+			equal(t, test.status, w.Code)
+			equal(t, test.chain, w.Header().Values("X-Chain"))
+			equal(t, test.allow, w.Header().Get("Allow"))
+			if test.body != "" {
+				equal(t, test.body, w.Body.String())
+			}
+		})
+	}
+}
+
+func TestRouter_URL(t *testing.T) {
+	rt := httpserver.NewRouter()
+	noop := func(http.ResponseWriter, *http.Request) {}
+
+	rt.Group("/api").Get("/users/{id}/posts/{$}", noop).Name("posts")
+	rt.Get("/files/{path...}", noop).Name("file")
+
+	u, err := rt.URL("posts", "id", "a b")
+	equal(t, nil, err)
+	equal(t, "/api/users/a%20b/posts/", u)
+
+	u, err = rt.URL("file", "path", "dir/my file.txt")
+	equal(t, nil, err)
+	equal(t, "/files/dir/my%20file.txt", u)
+
+	_, err = rt.URL("posts")
+	equal(t, true, err != nil && strings.Contains(err.Error(), `missing param "id"`))
+
+	_, err = rt.URL("unknown")
+	equal(t, true, errors.Is(err, httpserver.ErrRouteNotFound))
+
+	defer func() {
+		equal(t, true, recover() != nil)
+	}()
+	rt.Get("/other", noop).Name("file")
+}
+
+func TestRouter_Panics(t *testing.T) {
+	panics := func(fn func()) (p bool) {
+		defer func() {
+			p = recover() != nil
+		}()
+		fn()
+		return false
+	}
+
+	rt := httpserver.NewRouter()
+	rt.Use(tag("root"))
+
+	equal(t, true, panics(func() { rt.Group("api") }))
+	equal(t, false, panics(func() { rt.Group("") }))
+
+	api := rt.Group("/api")
+	rt.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+	// The chain of the root router is built, but a group still wraps its next routes.
+	equal(t, true, panics(func() { rt.Use(tag("late")) }))
+	equal(t, false, panics(func() { api.Use(tag("api")) }))
+}
@@ -1,13 +1,16 @@
 package httpserver
 
 import (
+	"bytes"
 	"context"
 	"errors"
 	"fmt"
 	"log/slog"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"strconv"
 	"sync"
 	"sync/atomic"
 	"syscall"
@@ -22,17 +25,22 @@ type Formatter interface {
 }
 
 // NewFormatter returns a new Formatter.
-func NewFormatter(coder coder.Coder) Formatter {
-	return &protoFormatter{Coder: coder}
+func NewFormatter(coder coder.Coder, opts ...FormatterOption) Formatter {
+	f := &protoFormatter{Coder: coder}
+	for _, opt := range opts {
+		opt(f)
+	}
+	return f
 }
 
 type protoFormatter struct {
 	coder.Coder
+	etag ETagMode
 }
 
 // WriteResponse encodes the value pointed to by v and writes it and statusCode to the stream.
 func (f *protoFormatter) WriteResponse(ctx context.Context, w http.ResponseWriter, statusCode int, v any) {
-	if v == nil {
+	if v == nil || !bodyAllowed(statusCode) {
 		w.WriteHeader(statusCode)
 		return
 	}
@@ -40,6 +48,12 @@ func (f *protoFormatter) WriteResponse(ctx context.Context, w http.ResponseWrite
 	if w.Header().Get(coder.ContentType) == "" && f.ContentType() != "" {
 		w.Header().Set(coder.ContentType, f.ContentType())
 	}
+
+	if f.etag != 0 && statusCode >= http.StatusOK && statusCode < http.StatusMultipleChoices {
+		f.writeConditional(ctx, w, statusCode, v)
+		return
+	}
+
 	w.WriteHeader(statusCode)
 	if err := f.Encode(ctx, w, v); err != nil {
 		slog.ErrorContext(ctx, "encode response", "error", err)
@@ -47,20 +61,127 @@ func (f *protoFormatter) WriteResponse(ctx context.Context, w http.ResponseWrite
 	}
 }
 
+// writeConditional encodes v into memory, tags it and answers 304 or 412 if a precondition of a GET
+// or HEAD request applies.
+func (f *protoFormatter) writeConditional(ctx context.Context, w http.ResponseWriter, statusCode int, v any) {
+	buf := new(bytes.Buffer)
+	if err := f.Encode(ctx, buf, v); err != nil {
+		slog.ErrorContext(ctx, "encode response", "error", err)
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+
+	h := w.Header()
+
+	etag := h.Get("ETag")
+	if etag == "" {
+		etag = NewETag(buf.Bytes(), f.etag)
+		h.Set("ETag", etag)
+	}
+
+	if p, _ := ctx.Value(preconditionsCtxKey{}).(*preconditions); p != nil &&
+		(p.method == http.MethodGet || p.method == http.MethodHead) {
+		lastModified, _ := http.ParseTime(h.Get("Last-Modified"))
+
+		switch p.evaluate(etag, lastModified) {
+		case http.StatusNotModified:
+			// A 304 response carries the validators and the cache headers, but no representation headers.
+			h.Del(coder.ContentType)
+			h.Del("Content-Length")
+			w.WriteHeader(http.StatusNotModified)
+			return
+		case http.StatusPreconditionFailed:
+			h.Del("ETag")
+			h.Del("Last-Modified")
+			f.WriteResponse(ctx, w, http.StatusPreconditionFailed, NewError(http.StatusPreconditionFailed, nil))
+			return
+		}
+	}
+
+	h.Set("Content-Length", strconv.Itoa(buf.Len()))
+	w.WriteHeader(statusCode)
+	if _, err := w.Write(buf.Bytes()); err != nil {
+		slog.DebugContext(ctx, "write response", "error", err)
+	}
+}
+
+// bodyAllowed reports whether a response with the status code may have a body (RFC 9110, 6.4.1).
+func bodyAllowed(statusCode int) bool {
+	return statusCode >= http.StatusOK && statusCode != http.StatusNoContent && statusCode != http.StatusNotModified
+}
+
+// ShutdownPhase describes the stage of a graceful shutdown.
+type ShutdownPhase int
+
+const (
+	// ShutdownStarted is reported once, when the shutdown begins.
+	ShutdownStarted ShutdownPhase = iota
+	// ShutdownDraining is reported every DrainInterval while connections are still open.
+	ShutdownDraining
+	// ShutdownForced is reported when GracefulTimeout expires and the remaining connections are closed.
+	ShutdownForced
+	// ShutdownCompleted is reported when all connections have been closed gracefully.
+	ShutdownCompleted
+)
+
+func (p ShutdownPhase) String() string {
+	switch p {
+	case ShutdownStarted:
+		return "started"
+	case ShutdownDraining:
+		return "draining"
+	case ShutdownForced:
+		return "forced"
+	case ShutdownCompleted:
+		return "completed"
+	default:
+		return "unknown"
+	}
+}
+
+// ShutdownProgress is passed to the OnShutdown callback and describes the progress of a graceful shutdown.
+//
+//	Phase — current stage of the shutdown.
+//	Conns — connections that were still open at the moment of the report.
+//	Elapsed — time passed since the shutdown began.
+type ShutdownProgress struct {
+	Phase   ShutdownPhase
+	Conns   ConnStats
+	Elapsed time.Duration
+}
+
+const defaultDrainInterval = time.Second
+
 // Controller is a wrapper around *http.Server to control the server.
 //
 //	Server — *http.Server, which will be managed.
-//	GracefulTimeout — time that is given to the server to shut down gracefully.
+//	GracefulTimeout — time that is given to the server to shut down gracefully; the connections still open
+//	  after it are closed forcibly. Zero only closes the listeners and the idle connections, and leaves
+//	  the active ones to finish on their own.
+//	DrainInterval — how often the shutdown progress is reported while connections are draining (1s by default).
+//	Protocol — HTTP protocols served by the server (see Protocol).
+//	HTTP2 — optional HTTP/2 settings applied to the server for ProtocolHTTP2 and ProtocolH2C (Go 1.24 or later).
+//
+// The Controller tracks client connections through the ConnState hook of the server.
+// A ConnState callback set by the user is still called after the Controller has recorded the state.
 type Controller struct {
 	Server          *http.Server
 	GracefulTimeout time.Duration
+	DrainInterval   time.Duration
+	Protocol        Protocol
+	HTTP2           *HTTP2Config
 
 	isRan   atomic.Bool
 	restart atomic.Bool
 
-	sigint  chan os.Signal
-	mu      sync.Mutex
-	onStart func(*http.Server)
+	sigint     chan os.Signal
+	mu         sync.Mutex
+	onStart    func(*http.Server)
+	onShutdown func(ShutdownProgress)
+
+	conns     *connTracker
+	connState func(net.Conn, http.ConnState)
+	shutdown  *sync.Once
 }
 
 // OnStart registers a callback function that is executed every time the controller starts or restarts.
@@ -72,8 +193,30 @@ func (c *Controller) OnStart(f func(*http.Server)) {
 	c.mu.Unlock()
 }
 
+// OnShutdown registers a callback function that receives the progress of every graceful shutdown.
+// It is called when the shutdown starts, every DrainInterval while connections are draining,
+// and once more when the shutdown is completed or forced.
+func (c *Controller) OnShutdown(f func(ShutdownProgress)) {
+	c.mu.Lock()
+	c.onShutdown = f
+	c.mu.Unlock()
+}
+
+// Connections returns the number of client connections currently open on the server, grouped by state.
+func (c *Controller) Connections() ConnStats {
+	c.mu.Lock()
+	conns := c.conns
+	c.mu.Unlock()
+
+	if conns == nil {
+		return ConnStats{}
+	}
+	return conns.stats()
+}
+
 // Start starts the *http.Server.
 // If *tls.Config on the server is non nil, the server listens and serves using tls.
+// Start returns after the server has been shut down and its connections have been drained.
 func (c *Controller) Start() (err error) {
 	for {
 		if err = c.start(); errors.Is(err, http.ErrServerClosed) {
@@ -112,46 +255,187 @@ func (c *Controller) Restart() {
 }
 
 // Shutdown gracefully shuts down the server.
+// While connections are draining, the progress is logged and passed to the OnShutdown callback every DrainInterval.
+// Connections that are still open after a positive GracefulTimeout, including hijacked ones, are closed forcibly.
+// Concurrent calls wait for the shutdown already in progress.
 func (c *Controller) Shutdown() {
+	c.mu.Lock()
+	once, conns := c.shutdown, c.conns
+	c.mu.Unlock()
+
+	if once == nil {
+		once, conns = new(sync.Once), newConnTracker()
+	}
+
+	once.Do(func() { c.drain(conns) })
+}
+
+func (c *Controller) drain(conns *connTracker) {
+	began := time.Now()
+
 	ctx, cancelWithTimeout := context.WithTimeout(context.Background(), c.GracefulTimeout)
 	defer cancelWithTimeout()
 
-	if err := c.Server.Shutdown(ctx); err != nil {
-		slog.Error(fmt.Sprintf("HTTP server shutdown: %s", err))
+	c.report(ShutdownProgress{Phase: ShutdownStarted, Conns: conns.stats()})
+
+	done := make(chan error, 1)
+	go func() { done <- c.Server.Shutdown(ctx) }()
+
+	interval := c.DrainInterval
+	if interval <= 0 {
+		interval = defaultDrainInterval
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case err := <-done:
+			if err != nil {
+				slog.Error(fmt.Sprintf("HTTP server shutdown: %s", err))
+				c.expire(conns, began)
+				return
+			}
+			// Server.Shutdown does not wait for hijacked connections, so keep draining them until the timeout.
+			done = nil
+		case <-ctx.Done():
+			if done != nil {
+				<-done
+			}
+			slog.Error(fmt.Sprintf("HTTP server shutdown: %s", ctx.Err()))
+			c.expire(conns, began)
+			return
+		case <-ticker.C:
+		}
+
+		stats := conns.stats()
+		if done == nil && stats.Total() == 0 {
+			c.report(ShutdownProgress{Phase: ShutdownCompleted, Elapsed: time.Since(began)})
+			return
+		}
+		c.report(ShutdownProgress{Phase: ShutdownDraining, Conns: stats, Elapsed: time.Since(began)})
+	}
+}
+
+// expire ends a shutdown whose GracefulTimeout has expired: the remaining connections are closed forcibly,
+// unless the timeout is zero.
+func (c *Controller) expire(conns *connTracker, began time.Time) {
+	if c.GracefulTimeout > 0 {
+		c.forceClose(conns, began)
+	}
+}
+
+// forceClose closes the listeners and every connection that is still open.
+func (c *Controller) forceClose(conns *connTracker, began time.Time) {
+	stats := conns.stats()
+
+	if err := c.Server.Close(); err != nil {
+		slog.Error(fmt.Sprintf("HTTP server close: %s", err))
+	}
+	conns.closeAll()
+
+	c.report(ShutdownProgress{Phase: ShutdownForced, Conns: stats, Elapsed: time.Since(began)})
+}
+
+func (c *Controller) report(p ShutdownProgress) {
+	level := slog.LevelInfo
+	if p.Phase == ShutdownForced {
+		level = slog.LevelWarn
+	}
+
+	slog.Log(context.Background(), level, "HTTP server shutdown",
+		slog.String("phase", p.Phase.String()),
+		slog.Group("connections",
+			slog.Int("new", p.Conns.New),
+			slog.Int("active", p.Conns.Active),
+			slog.Int("idle", p.Conns.Idle),
+			slog.Int("hijacked", p.Conns.Hijacked),
+		),
+		slog.String("elapsed", p.Elapsed.String()),
+	)
+
+	c.mu.Lock()
+	f := c.onShutdown
+	c.mu.Unlock()
+
+	if f != nil {
+		f(p)
 	}
 }
 
 func (c *Controller) start() error {
+	c.mu.Lock()
+	if err := c.Protocol.validate(c.Server); err != nil {
+		c.mu.Unlock()
+		return fmt.Errorf("HTTP server protocol %s: %w", c.Protocol, err)
+	}
+
+	if c.onStart != nil {
+		c.onStart(c.Server)
+	}
+
+	// The protocol is validated again, since onStart may change the TLS configuration.
+	if err := c.Protocol.apply(c.Server, c.HTTP2); err != nil {
+		c.mu.Unlock()
+		return fmt.Errorf("HTTP server protocol %s: %w", c.Protocol, err)
+	}
+
+	secure := c.Server.TLSConfig != nil
+
+	addr := c.Server.Addr
+	if addr == "" {
+		addr = ":http"
+		if secure {
+			addr = ":https"
+		}
+	}
+
+	// Nothing has been served if listening fails, so there is nothing to shut down either.
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		c.mu.Unlock()
+		if secure {
+			return fmt.Errorf("HTTP server ListenAndServeTLS: %w", err)
+		}
+		return fmt.Errorf("HTTP server ListenAndServe: %w", err)
+	}
+
+	c.conns, c.shutdown = newConnTracker(), new(sync.Once)
+	c.connState = c.Server.ConnState
+	c.Server.ConnState = c.conns.hook(c.connState)
+	conns := c.conns
+	c.mu.Unlock()
+
 	c.isRan.Store(true)
 
 	c.sigint = make(chan os.Signal, 1)
 	signal.Notify(c.sigint, syscall.SIGINT, syscall.SIGTERM)
 
+	stopped := make(chan struct{})
+
 	defer func() {
 		c.isRan.Store(false)
 		signal.Stop(c.sigint)
 		close(c.sigint)
+		<-stopped
 	}()
 
 	go func() {
+		defer close(stopped)
 		<-c.sigint
 		c.Shutdown()
 	}()
 
-	c.mu.Lock()
-	if c.onStart != nil {
-		c.onStart(c.Server)
-	}
-	c.mu.Unlock()
+	slog.Info("HTTP server serving", "secure", secure, "protocol", c.Protocol.String(), "address", c.Server.Addr)
 
-	secure := c.Server.TLSConfig != nil
-	slog.Info("HTTP server serving", "secure", secure, "address", c.Server.Addr)
+	ln = &trackedListener{Listener: ln, t: conns}
 
 	if secure {
-		err := c.Server.ListenAndServeTLS("", "")
+		err = c.Server.ServeTLS(ln, "", "")
 		return fmt.Errorf("HTTP server ListenAndServeTLS: %w", err)
 	} else {
-		err := c.Server.ListenAndServe()
+		err = c.Server.Serve(ln)
 		return fmt.Errorf("HTTP server ListenAndServe: %w", err)
 	}
 }
@@ -162,7 +446,7 @@ func (c *Controller) clone() {
 		c.Server.TLSConfig = nil
 	}
 
-	c.Server = &http.Server{
+	srv := &http.Server{
 		Addr:                         c.Server.Addr, // need to restart
 		Handler:                      c.Server.Handler,
 		DisableGeneralOptionsHandler: c.Server.DisableGeneralOptionsHandler,
@@ -173,9 +457,12 @@ func (c *Controller) clone() {
 		IdleTimeout:                  c.Server.IdleTimeout,
 		MaxHeaderBytes:               c.Server.MaxHeaderBytes,
 		TLSNextProto:                 c.Server.TLSNextProto, // need to restart
-		ConnState:                    c.Server.ConnState,    // need to restart
+		ConnState:                    c.connState,           // need to restart; the tracking hook is set on start
 		ErrorLog:                     c.Server.ErrorLog,
 		BaseContext:                  c.Server.BaseContext, // need to restart
 		ConnContext:                  c.Server.ConnContext, // need to restart
 	}
+	cloneProtocols(srv, c.Server)
+
+	c.Server = srv
 }
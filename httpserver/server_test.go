@@ -1,14 +1,20 @@
 package httpserver_test
 
 import (
+	"bufio"
 	"bytes"
+	"crypto/tls"
 	"encoding/json"
+	"errors"
 	"io"
 	"log/slog"
+	"net"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"sync"
 	"testing"
+	"time"
 
 	"github.com/easysy/proton/coder"
 	"github.com/easysy/proton/httpserver"
@@ -170,3 +176,218 @@ func TestProtoFormatter_ResponseContentType(t *testing.T) {
 		})
 	}
 }
+
+func freeAddr(t *testing.T) string {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	equal(t, nil, err)
+
+	addr := ln.Addr().String()
+	equal(t, nil, ln.Close())
+
+	return addr
+}
+
+func TestController_ShutdownForcesHijacked(t *testing.T) {
+	testShutdownForcesHijacked(t, nil)
+}
+
+func TestController_ShutdownForcesHijackedTLS(t *testing.T) {
+	ts := httptest.NewUnstartedServer(nil)
+	ts.StartTLS()
+	config := &tls.Config{Certificates: ts.TLS.Certificates}
+	ts.Close()
+
+	testShutdownForcesHijacked(t, config)
+}
+
+func TestController_ShutdownWithoutTimeout(t *testing.T) {
+	entered, release := make(chan struct{}), make(chan struct{})
+
+	srv := &http.Server{
+		Addr: freeAddr(t),
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			close(entered)
+			<-release
+			_, _ = io.WriteString(w, "done")
+		}),
+	}
+
+	var (
+		mu     sync.Mutex
+		phases []httpserver.ShutdownPhase
+	)
+
+	hcr := &httpserver.Controller{Server: srv}
+	hcr.OnShutdown(func(p httpserver.ShutdownProgress) {
+		mu.Lock()
+		defer mu.Unlock()
+		phases = append(phases, p.Phase)
+	})
+
+	started := make(chan struct{})
+	hcr.OnStart(func(*http.Server) { close(started) })
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- hcr.Start() }()
+
+	<-started
+
+	type result struct {
+		body string
+		err  error
+	}
+	results := make(chan result, 1)
+
+	go func() {
+		var (
+			resp *http.Response
+			err  error
+		)
+		for i := 0; i < 50; i++ {
+			if resp, err = http.Get("http://" + srv.Addr); err == nil {
+				break
+			}
+			time.Sleep(10 * time.Millisecond)
+		}
+		if err != nil {
+			results <- result{err: err}
+			return
+		}
+		defer func() { _ = resp.Body.Close() }()
+
+		b, err := io.ReadAll(resp.Body)
+		results <- result{body: string(b), err: err}
+	}()
+
+	<-entered
+
+	// A zero GracefulTimeout does not close the active connection forcibly.
+	hcr.Shutdown()
+	equal(t, nil, <-stopped)
+
+	close(release)
+
+	res := <-results
+	equal(t, nil, res.err)
+	equal(t, "done", res.body)
+
+	mu.Lock()
+	equal(t, []httpserver.ShutdownPhase{httpserver.ShutdownStarted}, phases)
+	mu.Unlock()
+}
+
+func testShutdownForcesHijacked(t *testing.T, config *tls.Config) {
+	hijacked := make(chan struct{})
+
+	srv := &http.Server{
+		Addr:      freeAddr(t),
+		TLSConfig: config,
+		Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			conn, buf, err := http.NewResponseController(w).Hijack()
+			equal(t, nil, err)
+
+			_, _ = buf.WriteString("HTTP/1.1 101 Switching Protocols\r\n\r\n")
+			_ = buf.Flush()
+
+			close(hijacked)
+
+			// The connection is intentionally left open, as a long-lived websocket would be.
+			_ = conn
+		}),
+	}
+
+	var (
+		mu     sync.Mutex
+		phases []httpserver.ShutdownPhase
+		forced httpserver.ConnStats
+	)
+
+	hcr := &httpserver.Controller{Server: srv, GracefulTimeout: 200 * time.Millisecond, DrainInterval: 50 * time.Millisecond}
+	hcr.OnShutdown(func(p httpserver.ShutdownProgress) {
+		mu.Lock()
+		defer mu.Unlock()
+
+		phases = append(phases, p.Phase)
+		if p.Phase == httpserver.ShutdownForced {
+			forced = p.Conns
+		}
+	})
+
+	started := make(chan struct{})
+	hcr.OnStart(func(*http.Server) { close(started) })
+
+	stopped := make(chan error, 1)
+	go func() { stopped <- hcr.Start() }()
+
+	<-started
+
+	var (
+		conn net.Conn
+		err  error
+	)
+	for i := 0; i < 50; i++ {
+		if config != nil {
+			conn, err = tls.Dial("tcp", srv.Addr, &tls.Config{InsecureSkipVerify: true})
+		} else {
+			conn, err = net.Dial("tcp", srv.Addr)
+		}
+		if err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	equal(t, nil, err)
+
+	defer func() { _ = conn.Close() }()
+
+	_, err = conn.Write([]byte("GET / HTTP/1.1\r\nHost: test\r\n\r\n"))
+	equal(t, nil, err)
+
+	<-hijacked
+
+	equal(t, 1, hcr.Connections().Hijacked)
+
+	hcr.Shutdown()
+	equal(t, nil, <-stopped)
+
+	mu.Lock()
+	equal(t, httpserver.ShutdownStarted, phases[0])
+	equal(t, httpserver.ShutdownForced, phases[len(phases)-1])
+	equal(t, 1, forced.Hijacked)
+	mu.Unlock()
+
+	equal(t, 0, hcr.Connections().Total())
+
+	reader := bufio.NewReader(conn)
+	_, _ = reader.ReadString('\n')
+	_, err = io.ReadAll(reader)
+	equal(t, nil, err)
+}
+
+func TestController_ListenError(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	equal(t, nil, err)
+
+	defer func() { _ = ln.Close() }()
+
+	hcr := &httpserver.Controller{Server: &http.Server{Addr: ln.Addr().String()}, GracefulTimeout: time.Second}
+
+	var reported bool
+	hcr.OnShutdown(func(httpserver.ShutdownProgress) { reported = true })
+
+	began := time.Now()
+	equal(t, true, hcr.Start() != nil)
+	equal(t, true, time.Since(began) < time.Second)
+	equal(t, false, reported)
+}
+
+func TestController_ProtocolHTTP2WithoutTLS(t *testing.T) {
+	hcr := &httpserver.Controller{Server: &http.Server{Addr: freeAddr(t)}, Protocol: httpserver.ProtocolHTTP2}
+
+	var started bool
+	hcr.OnStart(func(*http.Server) { started = true })
+
+	err := hcr.Start()
+	equal(t, true, errors.Is(err, httpserver.ErrProtocolNeedsTLS))
+	equal(t, false, started)
+}
@@ -0,0 +1,180 @@
+package httpserver
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+)
+
+// responseWriter wraps http.ResponseWriter to record the status code and the number of bytes written.
+// It keeps http.Flusher, http.Hijacker and io.ReaderFrom available to handlers
+// and implements Unwrap for http.ResponseController.
+type responseWriter struct {
+	http.ResponseWriter
+	status   int
+	size     int64
+	hijacked bool
+}
+
+func newResponseWriter(w http.ResponseWriter) *responseWriter {
+	return &responseWriter{ResponseWriter: w}
+}
+
+// Status returns the status code sent to the client, or http.StatusOK if the handler has not set it explicitly.
+func (w *responseWriter) Status() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+// Size returns the number of body bytes written to the client.
+func (w *responseWriter) Size() int64 {
+	return w.size
+}
+
+// Written reports whether the header has already been sent.
+func (w *responseWriter) Written() bool {
+	return w.status != 0
+}
+
+func (w *responseWriter) WriteHeader(statusCode int) {
+	// Informational headers (1xx) may be followed by the final one.
+	if w.status == 0 && (statusCode >= http.StatusOK || statusCode == http.StatusSwitchingProtocols) {
+		w.status = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *responseWriter) Write(p []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(p)
+	w.size += int64(n)
+	return n, err
+}
+
+func (w *responseWriter) ReadFrom(r io.Reader) (n int64, err error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if rf, ok := w.ResponseWriter.(io.ReaderFrom); ok {
+		n, err = rf.ReadFrom(r)
+	} else {
+		n, err = io.Copy(struct{ io.Writer }{w.ResponseWriter}, r)
+	}
+	w.size += n
+	return
+}
+
+func (w *responseWriter) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	_ = http.NewResponseController(w.ResponseWriter).Flush()
+}
+
+func (w *responseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	conn, rw, err := http.NewResponseController(w.ResponseWriter).Hijack()
+	if err == nil {
+		w.hijacked = true
+		if w.status == 0 {
+			w.status = http.StatusSwitchingProtocols
+		}
+	}
+	return conn, rw, err
+}
+
+// Unwrap returns the original http.ResponseWriter for http.ResponseController.
+func (w *responseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// dumpWriter writes the response through to the client and keeps a copy of the header
+// and of the first limit bytes of the body for dumping.
+type dumpWriter struct {
+	*responseWriter
+	header http.Header
+	body   bytes.Buffer
+	limit  int
+}
+
+func newDumpWriter(w http.ResponseWriter, limit int) *dumpWriter {
+	return &dumpWriter{responseWriter: newResponseWriter(w), limit: limit}
+}
+
+// snapshot saves the header as it is sent to the client.
+func (w *dumpWriter) snapshot() {
+	if w.header == nil {
+		w.header = w.Header().Clone()
+	}
+}
+
+func (w *dumpWriter) tee(p []byte) {
+	if n := w.limit - w.body.Len(); n > 0 {
+		w.body.Write(p[:min(n, len(p))])
+	}
+}
+
+func (w *dumpWriter) WriteHeader(statusCode int) {
+	if statusCode >= http.StatusOK || statusCode == http.StatusSwitchingProtocols {
+		w.snapshot()
+	}
+	w.responseWriter.WriteHeader(statusCode)
+}
+
+func (w *dumpWriter) Write(p []byte) (int, error) {
+	w.snapshot()
+	n, err := w.responseWriter.Write(p)
+	w.tee(p[:n])
+	return n, err
+}
+
+// ReadFrom copies the prefix through Write and the rest through the underlying io.ReaderFrom,
+// so that large files are still sent without extra copying.
+func (w *dumpWriter) ReadFrom(r io.Reader) (int64, error) {
+	w.snapshot()
+
+	var n int64
+	if rest := w.limit - w.body.Len(); rest > 0 {
+		var err error
+		if n, err = io.CopyN(struct{ io.Writer }{w}, r, int64(rest)); err != nil {
+			if err == io.EOF {
+				err = nil
+			}
+			return n, err
+		}
+	}
+
+	m, err := w.responseWriter.ReadFrom(r)
+	return n + m, err
+}
+
+func (w *dumpWriter) Flush() {
+	w.snapshot()
+	w.responseWriter.Flush()
+}
+
+// Response returns the response sent to the client with the kept prefix of the body.
+// Its ContentLength is the total number of body bytes written.
+func (w *dumpWriter) Response(r *http.Request) *http.Response {
+	w.snapshot()
+
+	status := w.Status()
+
+	return &http.Response{
+		Status:        strconv.Itoa(status) + " " + http.StatusText(status),
+		StatusCode:    status,
+		Proto:         r.Proto,
+		ProtoMajor:    r.ProtoMajor,
+		ProtoMinor:    r.ProtoMinor,
+		Header:        w.header,
+		Body:          io.NopCloser(bytes.NewReader(w.body.Bytes())),
+		ContentLength: w.Size(),
+		Request:       r,
+	}
+}
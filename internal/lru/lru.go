@@ -0,0 +1,96 @@
+// Package lru implements the size-limited least recently used cache behind the memory stores
+// of the httpclient and httpserver caches.
+package lru
+
+import (
+	"container/list"
+	"sync"
+)
+
+// Cache holds values by key up to a total size. When the size exceeds the limit,
+// the least recently used values are evicted. It is safe for concurrent use.
+type Cache[V any] struct {
+	mu      sync.Mutex
+	maxSize int64
+	size    int64
+	sizeOf  func(V) int64
+	stale   func(V) bool
+	lru     *list.List // of *item[V], the most recently used first
+	items   map[string]*list.Element
+}
+
+type item[V any] struct {
+	key   string
+	value V
+}
+
+// New returns a Cache holding at most maxSize, as measured by sizeOf. If stale is non nil,
+// values it reports are removed instead of being returned by Get.
+func New[V any](maxSize int64, sizeOf func(V) int64, stale func(V) bool) *Cache[V] {
+	return &Cache[V]{maxSize: maxSize, sizeOf: sizeOf, stale: stale, lru: list.New(), items: make(map[string]*list.Element)}
+}
+
+// Get returns the value stored under the key and true, or false if there is none.
+func (c *Cache[V]) Get(key string) (v V, ok bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[key]
+	if !ok {
+		return v, false
+	}
+
+	v = e.Value.(*item[V]).value
+	if c.stale != nil && c.stale(v) {
+		c.remove(key)
+		var zero V
+		return zero, false
+	}
+
+	c.lru.MoveToFront(e)
+	return v, true
+}
+
+// Set stores the value under the key, replacing the previous one. A value larger than the limit is not stored.
+func (c *Cache[V]) Set(key string, value V) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.remove(key)
+
+	size := c.sizeOf(value)
+	if size > c.maxSize {
+		return
+	}
+
+	c.items[key] = c.lru.PushFront(&item[V]{key: key, value: value})
+	c.size += size
+
+	for c.size > c.maxSize {
+		c.remove(c.lru.Back().Value.(*item[V]).key)
+	}
+}
+
+// Delete removes the value stored under the key, if any.
+func (c *Cache[V]) Delete(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.remove(key)
+}
+
+// Len returns the number of stored values.
+func (c *Cache[V]) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.lru.Len()
+}
+
+func (c *Cache[V]) remove(key string) {
+	if e, ok := c.items[key]; ok {
+		c.lru.Remove(e)
+		delete(c.items, key)
+		c.size -= c.sizeOf(e.Value.(*item[V]).value)
+	}
+}
@@ -0,0 +1,45 @@
+package lru_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/easysy/proton/internal/lru"
+)
+
+func equal(t *testing.T, exp, got any) {
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("Not equal:\nexp: %v\ngot: %v", exp, got)
+	}
+}
+
+func TestCache(t *testing.T) {
+	c := lru.New(10, func(v string) int64 { return int64(len(v)) }, func(v string) bool { return v == "stale" })
+
+	c.Set("a", "aaaa")
+	c.Set("b", "bbbb")
+	_, _ = c.Get("a")
+
+	// "b" is the least recently used value, so it is evicted first.
+	c.Set("c", "cccc")
+
+	_, ok := c.Get("b")
+	equal(t, false, ok)
+
+	v, ok := c.Get("a")
+	equal(t, true, ok)
+	equal(t, "aaaa", v)
+	equal(t, 2, c.Len())
+
+	c.Set("big", "01234567890")
+	_, ok = c.Get("big")
+	equal(t, false, ok)
+
+	c.Delete("c")
+	equal(t, 1, c.Len())
+
+	c.Set("s", "stale")
+	_, ok = c.Get("s")
+	equal(t, false, ok)
+	equal(t, 1, c.Len())
+}
@@ -0,0 +1,104 @@
+package log
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"sync"
+)
+
+// Well-known Metadata keys.
+const (
+	MetaUserID = "user_id"
+	MetaTenant = "tenant"
+	MetaRoute  = "route"
+)
+
+// WithTraceID returns a copy of ctx holding the trace ID.
+func WithTraceID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, TraceCtxKey, id)
+}
+
+// TraceID returns the trace ID of ctx: the trace of the current span, if any,
+// or the value stored under TraceCtxKey. A value of a type other than string
+// is accepted if it implements fmt.Stringer. It returns an empty string if ctx has no trace ID.
+func TraceID(ctx context.Context) string {
+	if span := SpanFromContext(ctx); span != nil {
+		return span.TraceID
+	}
+
+	switch id := ctx.Value(TraceCtxKey).(type) {
+	case string:
+		return id
+	case fmt.Stringer:
+		return id.String()
+	default:
+		return ""
+	}
+}
+
+// Metadata is a set of per-request attributes, such as the user ID, the tenant or the route.
+// It is shared by all contexts derived from the one it was attached to, so a value set
+// by an inner handler is visible to the outer middlewares. It is safe for concurrent use.
+type Metadata struct {
+	mu    sync.RWMutex
+	attrs []slog.Attr
+}
+
+// Set sets the value of the key, replacing the previous one.
+func (m *Metadata) Set(key string, value any) {
+	attr := slog.Any(key, value)
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for i := range m.attrs {
+		if m.attrs[i].Key == key {
+			m.attrs[i] = attr
+			return
+		}
+	}
+	m.attrs = append(m.attrs, attr)
+}
+
+// Get returns the value of the key.
+func (m *Metadata) Get(key string) (slog.Value, bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	for _, attr := range m.attrs {
+		if attr.Key == key {
+			return attr.Value, true
+		}
+	}
+	return slog.Value{}, false
+}
+
+// Attrs returns a copy of all attributes in the order they were first set.
+func (m *Metadata) Attrs() []slog.Attr {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return append([]slog.Attr(nil), m.attrs...)
+}
+
+// WithMetadata returns ctx with an empty Metadata attached, or ctx itself if it already has one.
+func WithMetadata(ctx context.Context) context.Context {
+	if MetadataFromContext(ctx) != nil {
+		return ctx
+	}
+	return context.WithValue(ctx, metadataCtxKey, new(Metadata))
+}
+
+// MetadataFromContext returns the Metadata attached to ctx, or nil.
+func MetadataFromContext(ctx context.Context) *Metadata {
+	m, _ := ctx.Value(metadataCtxKey).(*Metadata)
+	return m
+}
+
+// SetMetadata sets the value of the key in the Metadata of ctx, attaching a new Metadata if ctx has none.
+// The returned context must be used if a new Metadata was attached.
+func SetMetadata(ctx context.Context, key string, value any) context.Context {
+	ctx = WithMetadata(ctx)
+	MetadataFromContext(ctx).Set(key, value)
+	return ctx
+}
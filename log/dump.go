@@ -0,0 +1,194 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log/slog"
+	"mime"
+	"net/http"
+	"slices"
+	"strconv"
+)
+
+// bodyDump is the part of a message body included in a dump.
+type bodyDump struct {
+	contentType string
+	text        []byte // redacted text of a human-readable body
+	binary      bool
+	truncated   bool
+	total       int64 // total length of the body, or -1 if unknown
+}
+
+// readBodyDump reads the body for a dump: the redacted text of a human-readable body, truncated to MaxBodySize,
+// or nothing for a binary or content-encoded (e.g. compressed) one.
+// The body is replaced with a reader that returns the full original content.
+// It returns nil if there is no body.
+func readBodyDump(header http.Header, contentLength int64, body *io.ReadCloser) (*bodyDump, error) {
+	if *body == nil || *body == http.NoBody {
+		return nil, nil
+	}
+
+	contentType := header.Get("Content-Type")
+	bd := &bodyDump{contentType: contentType, total: -1}
+
+	if encoding := header.Get("Content-Encoding"); (encoding != "" && encoding != "identity") || !isHumanReadable(contentType) {
+		bd.binary = true
+		return bd, nil
+	}
+
+	p, truncated, err := readBody(body, MaxBodySize())
+	if err != nil {
+		return nil, err
+	}
+
+	if contentLength > 0 {
+		bd.total = contentLength
+	} else if !truncated {
+		bd.total = int64(len(p))
+	}
+
+	bd.truncated = truncated || bd.total > int64(len(p))
+
+	if bd.truncated {
+		bd.text = redaction.truncated(contentType, p)
+	} else {
+		bd.text = redaction.body(contentType, p)
+	}
+
+	return bd, nil
+}
+
+// readBody reads up to limit bytes of the body (the whole body if limit is zero)
+// and replaces the body with a reader that returns the full original content.
+func readBody(body *io.ReadCloser, limit int) (p []byte, truncated bool, err error) {
+	orig := *body
+
+	reader := io.Reader(orig)
+	if limit > 0 {
+		reader = io.LimitReader(orig, int64(limit)+1)
+	}
+
+	if p, err = io.ReadAll(reader); err != nil {
+		return nil, false, err
+	}
+
+	if limit > 0 && len(p) > limit {
+		// The rest of the body is left unread in the original reader.
+		*body = &prefixedBody{Reader: io.MultiReader(bytes.NewReader(p), orig), Closer: orig}
+		return p[:limit], true, nil
+	}
+
+	if err = orig.Close(); err != nil {
+		return nil, false, err
+	}
+
+	*body = io.NopCloser(bytes.NewReader(p))
+	return p, false, nil
+}
+
+// prefixedBody returns the already read prefix followed by the rest of the original body.
+type prefixedBody struct {
+	io.Reader
+	io.Closer
+}
+
+// appendTo appends the body to the wire format dump.
+func (bd *bodyDump) appendTo(dump []byte) []byte {
+	switch {
+	case bd == nil:
+		return dump
+	case bd.binary:
+		return append(dump, holder...)
+	case bd.truncated:
+		dump = append(dump, bd.text...)
+		return fmt.Appendf(dump, "\r\n<truncated: showing %d of %s bytes>\r\n", len(bd.text), bd.totalString())
+	default:
+		return append(dump, bd.text...)
+	}
+}
+
+func (bd *bodyDump) totalString() string {
+	if bd.total < 0 {
+		return "unknown"
+	}
+	return strconv.FormatInt(bd.total, 10)
+}
+
+// attrs returns the structured attributes of the body.
+// A complete JSON body is logged as a JSON value, other bodies as a string.
+func (bd *bodyDump) attrs() []slog.Attr {
+	switch {
+	case bd == nil:
+		return nil
+	case bd.binary:
+		return []slog.Attr{slog.String("body", "<binary body>")}
+	case bd.truncated:
+		return []slog.Attr{
+			slog.String("body", string(bd.text)),
+			slog.Bool("body_truncated", true),
+			slog.String("body_size", bd.totalString()),
+		}
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(bd.contentType); isJSON(mediaType) {
+		dec := json.NewDecoder(bytes.NewReader(bd.text))
+		dec.UseNumber()
+
+		var v any
+		if err := dec.Decode(&v); err == nil {
+			return []slog.Attr{slog.Any("body", v)}
+		}
+	}
+
+	return []slog.Attr{slog.String("body", string(bd.text))}
+}
+
+// headersAttr returns the headers as a group sorted by name.
+// Headers with several values are logged as a list.
+func headersAttr(h http.Header) slog.Attr {
+	keys := make([]string, 0, len(h))
+	for key := range h {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	attrs := make([]slog.Attr, 0, len(keys))
+	for _, key := range keys {
+		if values := h[key]; len(values) == 1 {
+			attrs = append(attrs, slog.String(key, values[0]))
+		} else {
+			attrs = append(attrs, slog.Any(key, values))
+		}
+	}
+
+	return slog.Attr{Key: "headers", Value: slog.GroupValue(attrs...)}
+}
+
+func requestAttr(r *http.Request, bd *bodyDump) slog.Attr {
+	uri := r.RequestURI
+	if uri == "" {
+		uri = r.URL.String()
+	}
+
+	attrs := []slog.Attr{
+		slog.String("method", r.Method),
+		slog.String("url", uri),
+		slog.String("proto", r.Proto),
+		slog.String("host", r.Host),
+		headersAttr(r.Header),
+	}
+
+	return slog.Attr{Key: "request", Value: slog.GroupValue(append(attrs, bd.attrs()...)...)}
+}
+
+func responseAttr(r *http.Response, bd *bodyDump) slog.Attr {
+	attrs := []slog.Attr{
+		slog.Int("status", r.StatusCode),
+		slog.String("proto", r.Proto),
+		headersAttr(r.Header),
+	}
+
+	return slog.Attr{Key: "response", Value: slog.GroupValue(append(attrs, bd.attrs()...)...)}
+}
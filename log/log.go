@@ -14,8 +14,15 @@ type contextKey int
 
 const (
 	TraceCtxKey contextKey = iota + 1
-	traceLogKey            = "trace_id"
-	holder                 = "<binary body>\r\n"
+	// TraceParentCtxKey holds the TraceParent received from the caller.
+	TraceParentCtxKey
+	// TraceStateCtxKey holds the W3C "tracestate" header value received from the caller.
+	TraceStateCtxKey
+	// SpanCtxKey holds the current *Span; use StartSpan and SpanFromContext.
+	SpanCtxKey
+	metadataCtxKey
+	traceLogKey = "trace_id"
+	holder      = "<binary body>\r\n"
 )
 
 var (
@@ -33,21 +40,50 @@ func SetTraceKey(key string) {
 }
 
 // TraceHandler allows the slog to add a trace ID to logs from the context.
-// To add a trace ID to the context, use TraceCtxKey:
+// To add a trace ID to the context, use WithTraceID:
 //
-//	ctx = context.WithValue(ctx, log.TraceCtxKey, 'your_id_here')
+//	ctx = log.WithTraceID(ctx, 'your_id_here')
+//
+// If the context holds a span started by StartSpan, the span ID ("span_id")
+// and the parent span ID ("parent_span_id") are added as well.
+// If Metadata is true, the attributes of the request Metadata (see SetMetadata) are added to every record.
 type TraceHandler struct {
 	slog.Handler
+	Metadata bool
 }
 
 func (h TraceHandler) Handle(ctx context.Context, r slog.Record) error {
-	if traceID, ok := ctx.Value(TraceCtxKey).(string); ok {
+	if span := SpanFromContext(ctx); span != nil {
+		r.AddAttrs(slog.String(traceKey, span.TraceID), slog.String(spanLogKey, span.SpanID))
+		if span.ParentID != "" {
+			r.AddAttrs(slog.String(parentSpanLogKey, span.ParentID))
+		}
+	} else if traceID := TraceID(ctx); traceID != "" {
 		r.Add(traceKey, slog.StringValue(traceID))
 	}
 
+	if h.Metadata {
+		if m := MetadataFromContext(ctx); m != nil {
+			r.AddAttrs(m.Attrs()...)
+		}
+	}
+
 	return h.Handler.Handle(ctx, r)
 }
 
+// WithAttrs returns a TraceHandler whose underlying handler has the attributes, so that loggers
+// derived with slog.Logger.With keep adding the trace attributes.
+func (h TraceHandler) WithAttrs(attrs []slog.Attr) slog.Handler {
+	h.Handler = h.Handler.WithAttrs(attrs)
+	return h
+}
+
+// WithGroup returns a TraceHandler whose underlying handler has the group.
+func (h TraceHandler) WithGroup(name string) slog.Handler {
+	h.Handler = h.Handler.WithGroup(name)
+	return h
+}
+
 var types = map[string]struct{}{
 	"json":                  {},
 	"ld+json":               {},
@@ -76,66 +112,124 @@ func SetHumanReadableSubTypes(list []string) {
 	}
 }
 
+const defaultMaxBodySize = 64 << 10
+
+var maxBodySize = defaultMaxBodySize
+
+// SetMaxBodySize sets the maximum number of body bytes included in dumps (64 KiB by default).
+// Longer bodies are truncated and annotated with their total length, if known.
+// A size less than or equal to zero disables truncation.
+// It must be called before any concurrent use of DumpHttpRequest or DumpHttpResponse.
+func SetMaxBodySize(size int) {
+	maxBodySize = size
+}
+
+// MaxBodySize returns the maximum number of body bytes included in dumps, or zero if it is unlimited.
+func MaxBodySize() int {
+	if maxBodySize <= 0 {
+		return 0
+	}
+	return maxBodySize
+}
+
+// DumpFormat selects how DumpHttpRequest and DumpHttpResponse log the dumps.
+type DumpFormat int
+
+const (
+	// DumpRaw logs the wire format of the message as a single "dump" string.
+	DumpRaw DumpFormat = iota
+	// DumpStructured logs the message as a "request" or "response" group with the method, URL, status,
+	// protocol, a group of headers and the body, which is logged as a JSON value when the Content-Type is JSON.
+	DumpStructured
+)
+
+var dumpFormat = DumpRaw
+
+// SetDumpFormat sets the format of dumps logged by DumpHttpRequest and DumpHttpResponse (DumpRaw by default).
+// It must be called before any concurrent use of DumpHttpRequest or DumpHttpResponse.
+func SetDumpFormat(f DumpFormat) {
+	dumpFormat = f
+}
+
 // DumpHttpRequest logs the full HTTP request using slog at the specified log level.
 // It uses DumpRequestOut for client requests and DumpRequest for server requests.
 // If body is true and the Content-Type is human-readable, the body is included in the dump.
-// If the body is binary, a placeholder is appended instead.
+// If the body is binary or content-encoded (e.g. compressed), a placeholder is appended instead.
+// Bodies longer than MaxBodySize are truncated in the dump, but the request keeps the full body.
+// Sensitive headers, query parameters and body fields are masked according to SetRedaction;
+// the request itself is left intact.
 func DumpHttpRequest(ctx context.Context, r *http.Request, level slog.Level, body bool) {
-	dumpFunc := httputil.DumpRequestOut
-	if r.URL.Scheme == "" || r.URL.Host == "" {
-		dumpFunc = httputil.DumpRequest
-	}
+	out := r.Clone(ctx)
+	redaction.header(out.Header)
+	out.URL.RawQuery = redaction.values(out.URL.RawQuery, redaction.query)
+	out.RequestURI = redaction.uri(out.RequestURI)
 
-	var binary bool
+	var (
+		bd  *bodyDump
+		err error
+	)
 
-	if body && r.Body != nil && r.Body != http.NoBody {
-		if body = isHumanReadable(r.Header.Get("Content-Type")); !body {
-			binary = true
+	if body {
+		if bd, err = readBodyDump(r.Header, r.ContentLength, &r.Body); err != nil {
+			slog.ErrorContext(ctx, "HTTP REQUEST", "error", err)
+			return
 		}
 	}
 
-	b, err := dumpFunc(r, body)
-	if err != nil {
-		slog.ErrorContext(ctx, "HTTP REQUEST", "error", err)
+	if dumpFormat == DumpStructured {
+		slog.LogAttrs(ctx, level, "HTTP REQUEST", requestAttr(out, bd))
 		return
 	}
 
-	if binary {
-		buf := make([]byte, len(b)+len(holder))
-		n := copy(buf, b)
-		copy(buf[n:], holder)
-		b = buf
+	dumpFunc := httputil.DumpRequestOut
+	if r.URL.Scheme == "" || r.URL.Host == "" {
+		dumpFunc = httputil.DumpRequest
+	}
+
+	b, err := dumpFunc(out, false)
+	if err != nil {
+		slog.ErrorContext(ctx, "HTTP REQUEST", "error", err)
+		return
 	}
 
-	slog.Log(ctx, level, "HTTP REQUEST", "dump", string(b))
+	slog.Log(ctx, level, "HTTP REQUEST", "dump", string(bd.appendTo(b)))
 }
 
 // DumpHttpResponse logs the full HTTP response using slog at the specified log level.
 // If body is true and the Content-Type is human-readable, the body is included in the dump.
-// If the body is binary, a placeholder is appended instead.
-func DumpHttpResponse(ctx context.Context, r *http.Response, level slog.Level, body bool) {
-	var binary bool
+// If the body is binary or content-encoded (e.g. compressed), a placeholder is appended instead.
+// Bodies longer than MaxBodySize are truncated in the dump, but the response keeps the full body.
+// Sensitive headers and body fields are masked according to SetRedaction; the response itself is left intact.
+// The attributes attrs, e.g. the cache status of the response, are added to the record.
+func DumpHttpResponse(ctx context.Context, r *http.Response, level slog.Level, body bool, attrs ...slog.Attr) {
+	out := *r
+	out.Header = r.Header.Clone()
+	redaction.header(out.Header)
+
+	var (
+		bd  *bodyDump
+		err error
+	)
 
-	if body && r.Body != nil && r.Body != http.NoBody {
-		if body = isHumanReadable(r.Header.Get("Content-Type")); !body {
-			binary = true
+	if body {
+		if bd, err = readBodyDump(r.Header, r.ContentLength, &r.Body); err != nil {
+			slog.ErrorContext(ctx, "HTTP RESPONSE", "error", err)
+			return
 		}
 	}
 
-	b, err := httputil.DumpResponse(r, body)
-	if err != nil {
-		slog.ErrorContext(ctx, "HTTP RESPONSE", "error", err)
+	if dumpFormat == DumpStructured {
+		slog.LogAttrs(ctx, level, "HTTP RESPONSE", append([]slog.Attr{responseAttr(&out, bd)}, attrs...)...)
 		return
 	}
 
-	if binary {
-		buf := make([]byte, len(b)+len(holder))
-		n := copy(buf, b)
-		copy(buf[n:], holder)
-		b = buf
+	b, err := httputil.DumpResponse(&out, false)
+	if err != nil {
+		slog.ErrorContext(ctx, "HTTP RESPONSE", "error", err)
+		return
 	}
 
-	slog.Log(ctx, level, "HTTP RESPONSE", "dump", string(b))
+	slog.LogAttrs(ctx, level, "HTTP RESPONSE", append([]slog.Attr{slog.String("dump", string(bd.appendTo(b)))}, attrs...)...)
 }
 
 // isHumanReadable reports whether the given Content-Type indicates text that is safe to log.
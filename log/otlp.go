@@ -0,0 +1,324 @@
+package log
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+var (
+	ErrExporterQueueFull = errors.New("span exporter queue is full")
+	ErrExporterClosed    = errors.New("span exporter is closed")
+)
+
+const (
+	otlpQueueSize     = 2048
+	otlpBatchSize     = 512
+	otlpFlushInterval = 2 * time.Second
+	otlpSendTimeout   = 10 * time.Second
+)
+
+// OTLPExporter sends spans in batches to an OpenTelemetry collector using OTLP/HTTP with the JSON encoding.
+// Spans are queued by ExportSpan and sent in the background; Close sends the remaining ones.
+// Spans dropped because the queue is full are counted and logged once per flush interval.
+// A batch is sent with a timeout of 10 seconds.
+type OTLPExporter struct {
+	url     string
+	service string
+	client  *http.Client
+
+	dropped  atomic.Int64
+	reported int64 // number of dropped spans already logged, owned by run
+
+	// ctx is canceled when Close gives up waiting, so that the batch being sent is abandoned.
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu     sync.RWMutex
+	closed bool
+	queue  chan *Span
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+// NewOTLPExporter returns a started OTLPExporter.
+//
+//	url — traces endpoint of the collector, e.g. "http://localhost:4318/v1/traces".
+//	service — value of the "service.name" resource attribute.
+//	client — client used to send the spans; http.DefaultClient if nil.
+//	  It must not be traced itself, otherwise every export produces new spans.
+func NewOTLPExporter(url, service string, client *http.Client) *OTLPExporter {
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	e := &OTLPExporter{
+		url:     url,
+		service: service,
+		client:  client,
+		queue:   make(chan *Span, otlpQueueSize),
+		stop:    make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	e.ctx, e.cancel = context.WithCancel(context.Background())
+
+	go e.run()
+
+	return e
+}
+
+// ExportSpan queues the span for sending. It returns ErrExporterQueueFull if the span is dropped,
+// and ErrExporterClosed after Close.
+func (e *OTLPExporter) ExportSpan(s *Span) error {
+	e.mu.RLock()
+	defer e.mu.RUnlock()
+
+	if e.closed {
+		return ErrExporterClosed
+	}
+
+	select {
+	case e.queue <- s:
+		return nil
+	default:
+		e.dropped.Add(1)
+		return ErrExporterQueueFull
+	}
+}
+
+// Dropped returns the number of spans dropped because the queue was full.
+func (e *OTLPExporter) Dropped() int64 {
+	return e.dropped.Load()
+}
+
+// reportDropped logs the number of spans dropped since the last report, if any.
+func (e *OTLPExporter) reportDropped() {
+	dropped := e.dropped.Load()
+	if n := dropped - e.reported; n > 0 {
+		slog.Warn("export spans", "error", ErrExporterQueueFull, "dropped", n)
+	}
+	e.reported = dropped
+}
+
+// Close stops the exporter and sends the queued spans, waiting until ctx is done at most.
+// Then the spans that are not sent yet are dropped, and the exporter stops in the background.
+func (e *OTLPExporter) Close(ctx context.Context) error {
+	e.mu.Lock()
+	if !e.closed {
+		e.closed = true
+		close(e.stop)
+	}
+	e.mu.Unlock()
+
+	select {
+	case <-e.done:
+		return nil
+	case <-ctx.Done():
+		e.cancel()
+		return ctx.Err()
+	}
+}
+
+func (e *OTLPExporter) run() {
+	defer close(e.done)
+	defer e.cancel()
+
+	ticker := time.NewTicker(otlpFlushInterval)
+	defer ticker.Stop()
+
+	batch := make([]*Span, 0, otlpBatchSize)
+
+	flush := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := e.send(batch); err != nil {
+			slog.Error("export spans", "error", err, "count", len(batch))
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case s := <-e.queue:
+			if batch = append(batch, s); len(batch) == otlpBatchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+			e.reportDropped()
+		case <-e.stop:
+			for {
+				select {
+				case s := <-e.queue:
+					if batch = append(batch, s); len(batch) == otlpBatchSize {
+						flush()
+					}
+				default:
+					flush()
+					e.reportDropped()
+					return
+				}
+			}
+		}
+	}
+}
+
+func (e *OTLPExporter) send(spans []*Span) error {
+	request := e.request(spans)
+	if len(request.ResourceSpans[0].ScopeSpans[0].Spans) == 0 {
+		return nil
+	}
+
+	b, err := json.Marshal(request)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(e.ctx, otlpSendTimeout)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, e.url, bytes.NewReader(b))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+
+	defer Closer(context.Background(), resp.Body)
+
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode/100 != 2 {
+		return fmt.Errorf("OTLP collector responded with %s", resp.Status)
+	}
+	return nil
+}
+
+// OTLP/JSON ExportTraceServiceRequest; identifiers are hex-encoded and 64-bit integers are strings.
+type (
+	otlpRequest struct {
+		ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+	}
+	otlpResourceSpans struct {
+		Resource   otlpResource     `json:"resource"`
+		ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+	}
+	otlpResource struct {
+		Attributes []otlpKeyValue `json:"attributes"`
+	}
+	otlpScopeSpans struct {
+		Scope otlpScope  `json:"scope"`
+		Spans []otlpSpan `json:"spans"`
+	}
+	otlpScope struct {
+		Name string `json:"name"`
+	}
+	otlpSpan struct {
+		TraceID           string         `json:"traceId"`
+		SpanID            string         `json:"spanId"`
+		ParentSpanID      string         `json:"parentSpanId,omitempty"`
+		Name              string         `json:"name"`
+		Kind              int            `json:"kind"`
+		StartTimeUnixNano string         `json:"startTimeUnixNano"`
+		EndTimeUnixNano   string         `json:"endTimeUnixNano"`
+		Attributes        []otlpKeyValue `json:"attributes,omitempty"`
+		Status            *otlpStatus    `json:"status,omitempty"`
+	}
+	otlpStatus struct {
+		Code    int    `json:"code"`
+		Message string `json:"message,omitempty"`
+	}
+	otlpKeyValue struct {
+		Key   string       `json:"key"`
+		Value otlpAnyValue `json:"value"`
+	}
+	otlpAnyValue struct {
+		StringValue *string  `json:"stringValue,omitempty"`
+		BoolValue   *bool    `json:"boolValue,omitempty"`
+		IntValue    *string  `json:"intValue,omitempty"`
+		DoubleValue *float64 `json:"doubleValue,omitempty"`
+	}
+)
+
+const otlpStatusError = 2
+
+func (e *OTLPExporter) request(spans []*Span) *otlpRequest {
+	out := make([]otlpSpan, 0, len(spans))
+
+	for _, s := range spans {
+		// OTLP requires W3C identifiers; spans of traces started from a legacy request ID are skipped.
+		if !IsTraceID(s.TraceID) {
+			continue
+		}
+
+		span := otlpSpan{
+			TraceID:           s.TraceID,
+			SpanID:            s.SpanID,
+			ParentSpanID:      s.ParentID,
+			Name:              s.Name,
+			Kind:              int(s.Kind),
+			StartTimeUnixNano: strconv.FormatInt(s.Start.UnixNano(), 10),
+			EndTimeUnixNano:   strconv.FormatInt(s.EndTime().UnixNano(), 10),
+			Attributes:        otlpAttributes("", s.Attributes(), nil),
+		}
+
+		if msg := s.Err(); msg != "" {
+			span.Status = &otlpStatus{Code: otlpStatusError, Message: msg}
+		}
+
+		out = append(out, span)
+	}
+
+	return &otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: otlpAttributes("", []slog.Attr{slog.String("service.name", e.service)}, nil)},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "github.com/easysy/proton"}, Spans: out}},
+	}}}
+}
+
+// otlpAttributes flattens attributes, joining the keys of groups with dots.
+func otlpAttributes(prefix string, attrs []slog.Attr, out []otlpKeyValue) []otlpKeyValue {
+	for _, attr := range attrs {
+		key := prefix + attr.Key
+		value := attr.Value.Resolve()
+
+		var v otlpAnyValue
+
+		switch value.Kind() {
+		case slog.KindGroup:
+			out = otlpAttributes(key+".", value.Group(), out)
+			continue
+		case slog.KindBool:
+			b := value.Bool()
+			v.BoolValue = &b
+		case slog.KindInt64:
+			i := strconv.FormatInt(value.Int64(), 10)
+			v.IntValue = &i
+		case slog.KindUint64:
+			i := strconv.FormatUint(value.Uint64(), 10)
+			v.IntValue = &i
+		case slog.KindFloat64:
+			f := value.Float64()
+			v.DoubleValue = &f
+		default:
+			s := value.String()
+			v.StringValue = &s
+		}
+
+		out = append(out, otlpKeyValue{Key: key, Value: v})
+	}
+	return out
+}
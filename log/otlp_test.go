@@ -0,0 +1,232 @@
+package log_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/easysy/proton/log"
+)
+
+func equal(t *testing.T, exp, got any) {
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("Not equal:\nexp: %v\ngot: %v", exp, got)
+	}
+}
+
+type collectedSpan struct {
+	TraceID      string `json:"traceId"`
+	SpanID       string `json:"spanId"`
+	ParentSpanID string `json:"parentSpanId"`
+	Name         string `json:"name"`
+	Kind         int    `json:"kind"`
+	Attributes   []struct {
+		Key   string         `json:"key"`
+		Value map[string]any `json:"value"`
+	} `json:"attributes"`
+	Status *struct {
+		Code    int    `json:"code"`
+		Message string `json:"message"`
+	} `json:"status"`
+}
+
+type collectedRequest struct {
+	ResourceSpans []struct {
+		Resource struct {
+			Attributes []struct {
+				Key   string         `json:"key"`
+				Value map[string]any `json:"value"`
+			} `json:"attributes"`
+		} `json:"resource"`
+		ScopeSpans []struct {
+			Scope struct {
+				Name string `json:"name"`
+			} `json:"scope"`
+			Spans []collectedSpan `json:"spans"`
+		} `json:"scopeSpans"`
+	} `json:"resourceSpans"`
+}
+
+func (r *collectedRequest) spans() []collectedSpan {
+	return r.ResourceSpans[0].ScopeSpans[0].Spans
+}
+
+// collector is an OTLP/HTTP collector passing every received request to the returned channel.
+// A handler blocks until release is closed, if it is non nil.
+func collector(t *testing.T, release chan struct{}) (*httptest.Server, chan *collectedRequest) {
+	requests := make(chan *collectedRequest, 16)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost || r.URL.Path != "/v1/traces" || r.Header.Get("Content-Type") != "application/json" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+
+		request := new(collectedRequest)
+		if err := json.NewDecoder(r.Body).Decode(request); err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		requests <- request
+
+		if release != nil {
+			<-release
+		}
+	}))
+	t.Cleanup(srv.Close)
+
+	return srv, requests
+}
+
+func endedSpan(ctx context.Context, name string, kind log.SpanKind, attrs ...slog.Attr) (context.Context, *log.Span) {
+	ctx, span := log.StartSpan(ctx, name, kind, attrs...)
+	span.End()
+	return ctx, span
+}
+
+func TestOTLPExporter_Payload(t *testing.T) {
+	srv, requests := collector(t, nil)
+
+	e := log.NewOTLPExporter(srv.URL+"/v1/traces", "users", nil)
+
+	ctx, parent := endedSpan(context.Background(), "GET /users/{id}", log.SpanKindServer,
+		slog.String("http.method", "GET"), slog.Int("http.status_code", 500), slog.Bool("retry", true),
+		slog.Group("db", slog.String("system", "postgresql")))
+
+	_, child := log.StartSpan(ctx, "query", log.SpanKindClient)
+	child.SetError(errors.New("timeout"))
+	child.End()
+
+	_, legacy := endedSpan(log.WithTraceID(context.Background(), "request-1"), "legacy", log.SpanKindInternal)
+
+	for _, s := range []*log.Span{parent, child, legacy} {
+		equal(t, nil, e.ExportSpan(s))
+	}
+	equal(t, nil, e.Close(context.Background()))
+
+	request := <-requests
+
+	equal(t, 1, len(request.ResourceSpans))
+	equal(t, "service.name", request.ResourceSpans[0].Resource.Attributes[0].Key)
+	equal(t, map[string]any{"stringValue": "users"}, request.ResourceSpans[0].Resource.Attributes[0].Value)
+	equal(t, "github.com/easysy/proton", request.ResourceSpans[0].ScopeSpans[0].Scope.Name)
+
+	spans := request.spans()
+	equal(t, 2, len(spans))
+
+	equal(t, parent.TraceID, spans[0].TraceID)
+	equal(t, parent.SpanID, spans[0].SpanID)
+	equal(t, "", spans[0].ParentSpanID)
+	equal(t, "GET /users/{id}", spans[0].Name)
+	equal(t, int(log.SpanKindServer), spans[0].Kind)
+
+	attributes := make(map[string]map[string]any)
+	for _, attr := range spans[0].Attributes {
+		attributes[attr.Key] = attr.Value
+	}
+	equal(t, map[string]map[string]any{
+		"http.method":      {"stringValue": "GET"},
+		"http.status_code": {"intValue": "500"},
+		"retry":            {"boolValue": true},
+		"db.system":        {"stringValue": "postgresql"},
+	}, attributes)
+
+	equal(t, parent.TraceID, spans[1].TraceID)
+	equal(t, parent.SpanID, spans[1].ParentSpanID)
+	equal(t, 2, spans[1].Status.Code)
+	equal(t, "timeout", spans[1].Status.Message)
+}
+
+func TestOTLPExporter_Batching(t *testing.T) {
+	srv, requests := collector(t, nil)
+
+	e := log.NewOTLPExporter(srv.URL+"/v1/traces", "users", nil)
+
+	_, span := endedSpan(context.Background(), "span", log.SpanKindInternal)
+
+	for range 512 + 3 {
+		equal(t, nil, e.ExportSpan(span))
+	}
+
+	// A full batch is sent at once, without waiting for the flush interval or Close.
+	select {
+	case request := <-requests:
+		equal(t, 512, len(request.spans()))
+	case <-time.After(time.Second):
+		t.Fatal("the full batch is not sent")
+	}
+
+	equal(t, nil, e.Close(context.Background()))
+
+	request := <-requests
+	equal(t, 3, len(request.spans()))
+
+	select {
+	case <-requests:
+		t.Fatal("unexpected request")
+	default:
+	}
+}
+
+func TestOTLPExporter_Dropped(t *testing.T) {
+	release := make(chan struct{})
+	srv, requests := collector(t, release)
+
+	e := log.NewOTLPExporter(srv.URL+"/v1/traces", "users", nil)
+
+	_, span := endedSpan(context.Background(), "span", log.SpanKindInternal)
+
+	// The first batch blocks the exporter in the collector, so the queue is not drained any more.
+	for range 512 {
+		equal(t, nil, e.ExportSpan(span))
+	}
+	<-requests
+
+	for range 2048 {
+		equal(t, nil, e.ExportSpan(span))
+	}
+	for range 10 {
+		equal(t, log.ErrExporterQueueFull, e.ExportSpan(span))
+	}
+	equal(t, int64(10), e.Dropped())
+
+	close(release)
+	equal(t, nil, e.Close(context.Background()))
+
+	sent := 0
+	for range 4 {
+		sent += len((<-requests).spans())
+	}
+	equal(t, 2048, sent)
+}
+
+func TestOTLPExporter_Close(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+
+	srv, requests := collector(t, release)
+
+	e := log.NewOTLPExporter(srv.URL+"/v1/traces", "users", nil)
+
+	_, span := endedSpan(context.Background(), "span", log.SpanKindInternal)
+	equal(t, nil, e.ExportSpan(span))
+
+	// The collector never answers: Close gives up at its deadline and cancels the send.
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	equal(t, context.DeadlineExceeded, e.Close(ctx))
+	<-requests
+
+	ctx, cancel = context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+
+	equal(t, nil, e.Close(ctx))
+	equal(t, log.ErrExporterClosed, e.ExportSpan(span))
+}
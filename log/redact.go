@@ -0,0 +1,239 @@
+package log
+
+import (
+	"bytes"
+	"encoding/json"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const defaultMask = "[REDACTED]"
+
+// Redaction configures which sensitive data is masked in HTTP dumps and coder debug logs.
+type Redaction struct {
+	// Headers lists the request and response headers whose values are masked (case-insensitive).
+	Headers []string
+
+	// Query lists the URL query parameters whose values are masked.
+	Query []string
+
+	// Fields lists the body fields whose values are masked.
+	// For JSON bodies a field is a dot-separated path from the root object, e.g. "user.password";
+	// "*" matches any key, and arrays are traversed without a path segment, so "items.token"
+	// matches the "token" of every element of "items".
+	// For form bodies (application/x-www-form-urlencoded) a field is the name of a form value.
+	// Go values logged by the coder package are matched by their JSON representation.
+	Fields []string
+
+	// Mask replaces the redacted values. Defaults to "[REDACTED]".
+	Mask string
+}
+
+// DefaultRedaction masks the credentials sent in headers.
+var DefaultRedaction = Redaction{
+	Headers: []string{"Authorization", "Proxy-Authorization", "Cookie", "Set-Cookie"},
+}
+
+type redactor struct {
+	headers []string
+	query   map[string]struct{}
+	form    map[string]struct{}
+	fields  [][]string
+	mask    string
+}
+
+var redaction = newRedactor(DefaultRedaction)
+
+// SetRedaction replaces the redaction rules applied by DumpHttpRequest, DumpHttpResponse, RedactBody and RedactValue.
+// DefaultRedaction is used until it is called; pass an empty Redaction to disable masking.
+// It must be called before any concurrent use of DumpHttpRequest or DumpHttpResponse.
+func SetRedaction(r Redaction) {
+	redaction = newRedactor(r)
+}
+
+func newRedactor(r Redaction) *redactor {
+	rd := &redactor{
+		headers: make([]string, 0, len(r.Headers)),
+		query:   make(map[string]struct{}, len(r.Query)),
+		form:    make(map[string]struct{}, len(r.Fields)),
+		fields:  make([][]string, 0, len(r.Fields)),
+		mask:    r.Mask,
+	}
+
+	if rd.mask == "" {
+		rd.mask = defaultMask
+	}
+
+	for _, h := range r.Headers {
+		rd.headers = append(rd.headers, http.CanonicalHeaderKey(strings.TrimSpace(h)))
+	}
+
+	for _, q := range r.Query {
+		rd.query[q] = struct{}{}
+	}
+
+	for _, f := range r.Fields {
+		rd.form[f] = struct{}{}
+		rd.fields = append(rd.fields, strings.Split(f, "."))
+	}
+
+	return rd
+}
+
+// header masks the denylisted headers of h in place.
+func (rd *redactor) header(h http.Header) {
+	for _, key := range rd.headers {
+		values := h[key]
+		for i := range values {
+			values[i] = rd.mask
+		}
+	}
+}
+
+// values masks the values of the listed keys in a URL-encoded string, keeping the order of the pairs.
+func (rd *redactor) values(s string, keys map[string]struct{}) string {
+	if len(keys) == 0 || s == "" {
+		return s
+	}
+
+	pairs := strings.Split(s, "&")
+
+	var changed bool
+	for i, pair := range pairs {
+		raw, _, _ := strings.Cut(pair, "=")
+		key := raw
+		if k, err := url.QueryUnescape(raw); err == nil {
+			key = k
+		}
+		if _, ok := keys[key]; ok {
+			pairs[i] = raw + "=" + rd.mask
+			changed = true
+		}
+	}
+
+	if !changed {
+		return s
+	}
+	return strings.Join(pairs, "&")
+}
+
+// uri masks the query parameters of a request URI.
+func (rd *redactor) uri(uri string) string {
+	path, query, ok := strings.Cut(uri, "?")
+	if !ok {
+		return uri
+	}
+	return path + "?" + rd.values(query, rd.query)
+}
+
+// body masks the fields of a JSON or form body. Other bodies are returned as is.
+// If contentType is empty, the body is treated as JSON when it is valid JSON.
+func (rd *redactor) body(contentType string, p []byte) []byte {
+	if len(rd.fields) == 0 || len(p) == 0 {
+		return p
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+
+	switch {
+	case mediaType == "application/x-www-form-urlencoded":
+		return []byte(rd.values(string(p), rd.form))
+	case mediaType == "" || isJSON(mediaType):
+		return rd.json(p)
+	default:
+		return p
+	}
+}
+
+// truncated masks the fields of a truncated body.
+// A truncated JSON document cannot be parsed, so it is masked entirely when any fields are configured.
+func (rd *redactor) truncated(contentType string, p []byte) []byte {
+	if len(rd.fields) == 0 {
+		return p
+	}
+
+	if mediaType, _, _ := mime.ParseMediaType(contentType); isJSON(mediaType) {
+		return []byte(rd.mask)
+	}
+	return rd.body(contentType, p)
+}
+
+func isJSON(mediaType string) bool {
+	return strings.HasSuffix(mediaType, "/json") || strings.HasSuffix(mediaType, "+json")
+}
+
+func (rd *redactor) json(p []byte) []byte {
+	dec := json.NewDecoder(bytes.NewReader(p))
+	dec.UseNumber()
+
+	var v any
+	if err := dec.Decode(&v); err != nil {
+		return p
+	}
+
+	var changed bool
+	for _, path := range rd.fields {
+		if rd.walk(v, path) {
+			changed = true
+		}
+	}
+
+	if !changed {
+		return p
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return p
+	}
+	return b
+}
+
+// walk masks the values found by path in v and reports whether anything was masked.
+func (rd *redactor) walk(v any, path []string) (changed bool) {
+	switch t := v.(type) {
+	case []any:
+		for _, e := range t {
+			if rd.walk(e, path) {
+				changed = true
+			}
+		}
+	case map[string]any:
+		for key, value := range t {
+			if path[0] != "*" && path[0] != key {
+				continue
+			}
+			if len(path) == 1 {
+				t[key] = rd.mask
+				changed = true
+			} else if rd.walk(value, path[1:]) {
+				changed = true
+			}
+		}
+	}
+	return
+}
+
+// RedactBody masks the configured fields in a JSON or form body.
+// If contentType is empty, p is treated as JSON when it is valid JSON.
+// Bodies of other types are returned unchanged.
+func RedactBody(contentType string, p []byte) []byte {
+	return redaction.body(contentType, p)
+}
+
+// RedactValue returns v ready for logging with the configured fields masked.
+// If no fields are configured, v is returned unchanged;
+// otherwise the JSON representation of v with the fields masked is returned as a string.
+func RedactValue(v any) any {
+	if len(redaction.fields) == 0 || v == nil {
+		return v
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return redaction.mask
+	}
+	return string(redaction.json(b))
+}
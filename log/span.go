@@ -0,0 +1,238 @@
+package log
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+)
+
+const (
+	spanLogKey       = "span_id"
+	parentSpanLogKey = "parent_span_id"
+)
+
+// SpanKind describes the relationship between a span, its parent and its children.
+type SpanKind int
+
+const (
+	SpanKindInternal SpanKind = iota + 1
+	SpanKindServer
+	SpanKindClient
+)
+
+func (k SpanKind) String() string {
+	switch k {
+	case SpanKindInternal:
+		return "internal"
+	case SpanKindServer:
+		return "server"
+	case SpanKindClient:
+		return "client"
+	default:
+		return "unspecified"
+	}
+}
+
+// Span is a timed unit of work within a trace.
+// The identifiers, the name, the kind and the start time are set by StartSpan and must not be changed,
+// except for the name, which may be refined by SetName before End, e.g. once the route is known.
+type Span struct {
+	TraceID  string
+	SpanID   string
+	ParentID string
+	Name     string
+	Kind     SpanKind
+	Start    time.Time
+
+	mu    sync.Mutex
+	end   time.Time
+	attrs []slog.Attr
+	err   string
+}
+
+// StartSpan starts a new span and returns a context holding it.
+// The span is a child of the span in ctx, if any; otherwise it continues the trace of
+// the TraceParent or the trace ID in ctx, or starts a new trace.
+// The returned context also holds the trace ID under TraceCtxKey.
+// The span must be finished by calling End.
+func StartSpan(ctx context.Context, name string, kind SpanKind, attrs ...slog.Attr) (context.Context, *Span) {
+	s := &Span{SpanID: NewSpanID(), Name: name, Kind: kind, Start: time.Now(), attrs: attrs}
+
+	if parent := SpanFromContext(ctx); parent != nil {
+		s.TraceID, s.ParentID = parent.TraceID, parent.SpanID
+	} else if remote, ok := ctx.Value(TraceParentCtxKey).(TraceParent); ok {
+		s.TraceID, s.ParentID = remote.TraceID, remote.SpanID
+	} else if traceID := TraceID(ctx); traceID != "" {
+		s.TraceID = traceID
+	} else {
+		s.TraceID = NewTraceID()
+	}
+
+	ctx = context.WithValue(ctx, SpanCtxKey, s)
+
+	return WithTraceID(ctx, s.TraceID), s
+}
+
+// SpanFromContext returns the span stored in ctx by StartSpan, or nil.
+func SpanFromContext(ctx context.Context) *Span {
+	s, _ := ctx.Value(SpanCtxKey).(*Span)
+	return s
+}
+
+// SetName renames the span. Calls after End are ignored.
+func (s *Span) SetName(name string) {
+	s.mu.Lock()
+	if s.end.IsZero() {
+		s.Name = name
+	}
+	s.mu.Unlock()
+}
+
+// SetAttributes adds attributes to the span. Attributes set after End are ignored.
+func (s *Span) SetAttributes(attrs ...slog.Attr) {
+	s.mu.Lock()
+	if s.end.IsZero() {
+		s.attrs = append(s.attrs, attrs...)
+	}
+	s.mu.Unlock()
+}
+
+// SetError marks the span as failed with the error message.
+func (s *Span) SetError(err error) {
+	if err == nil {
+		return
+	}
+	s.mu.Lock()
+	if s.end.IsZero() {
+		s.err = err.Error()
+	}
+	s.mu.Unlock()
+}
+
+// End finishes the span and passes it to the exporter set by SetSpanExporter.
+// Calls after the first one have no effect.
+func (s *Span) End() {
+	s.mu.Lock()
+	if !s.end.IsZero() {
+		s.mu.Unlock()
+		return
+	}
+	s.end = time.Now()
+	s.mu.Unlock()
+
+	if exporter == nil {
+		return
+	}
+
+	// A full queue is reported by the exporter itself, rather than once for every dropped span.
+	if err := exporter.ExportSpan(s); err != nil && !errors.Is(err, ErrExporterQueueFull) {
+		slog.Error("export span", "error", err)
+	}
+}
+
+// EndTime returns the time the span was finished, or the zero time if it is still running.
+func (s *Span) EndTime() time.Time {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.end
+}
+
+// Attributes returns a copy of the span attributes.
+func (s *Span) Attributes() []slog.Attr {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]slog.Attr(nil), s.attrs...)
+}
+
+// Err returns the error message set by SetError.
+func (s *Span) Err() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.err
+}
+
+// A SpanExporter receives every finished span.
+// ExportSpan is called synchronously by Span.End, so implementations should not block for long.
+type SpanExporter interface {
+	ExportSpan(s *Span) error
+}
+
+var exporter SpanExporter
+
+// SetSpanExporter sets the exporter of finished spans. Spans are not exported by default.
+// It must be called before any concurrent use of StartSpan.
+func SetSpanExporter(e SpanExporter) {
+	exporter = e
+}
+
+type writerExporter struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+// NewWriterExporter returns a SpanExporter that writes every span to w as a line of JSON,
+// e.g. to a file opened for appending.
+func NewWriterExporter(w io.Writer) SpanExporter {
+	return &writerExporter{w: w}
+}
+
+type spanJSON struct {
+	TraceID    string         `json:"trace_id"`
+	SpanID     string         `json:"span_id"`
+	ParentID   string         `json:"parent_span_id,omitempty"`
+	Name       string         `json:"name"`
+	Kind       string         `json:"kind"`
+	Start      time.Time      `json:"start"`
+	End        time.Time      `json:"end"`
+	Duration   string         `json:"duration"`
+	Attributes map[string]any `json:"attributes,omitempty"`
+	Error      string         `json:"error,omitempty"`
+}
+
+func (e *writerExporter) ExportSpan(s *Span) error {
+	end := s.EndTime()
+
+	b, err := json.Marshal(&spanJSON{
+		TraceID:    s.TraceID,
+		SpanID:     s.SpanID,
+		ParentID:   s.ParentID,
+		Name:       s.Name,
+		Kind:       s.Kind.String(),
+		Start:      s.Start,
+		End:        end,
+		Duration:   end.Sub(s.Start).String(),
+		Attributes: attrsMap(s.Attributes()),
+		Error:      s.Err(),
+	})
+	if err != nil {
+		return err
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	_, err = e.w.Write(append(b, '\n'))
+	return err
+}
+
+// attrsMap converts attributes to a map, nesting groups.
+func attrsMap(attrs []slog.Attr) map[string]any {
+	if len(attrs) == 0 {
+		return nil
+	}
+
+	m := make(map[string]any, len(attrs))
+	for _, attr := range attrs {
+		value := attr.Value.Resolve()
+		if value.Kind() == slog.KindGroup {
+			m[attr.Key] = attrsMap(value.Group())
+		} else {
+			m[attr.Key] = value.Any()
+		}
+	}
+	return m
+}
@@ -0,0 +1,105 @@
+package log
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"strings"
+)
+
+// W3C Trace Context headers (https://www.w3.org/TR/trace-context/).
+const (
+	TraceParentHeader = "Traceparent"
+	TraceStateHeader  = "Tracestate"
+)
+
+// TraceFlagSampled is the "sampled" bit of the trace flags.
+const TraceFlagSampled byte = 0x01
+
+// TraceParent is the value of the W3C "traceparent" header.
+//
+//	TraceID — 32 lowercase hex characters identifying the whole trace.
+//	SpanID — 16 lowercase hex characters identifying the caller's span (the "parent-id").
+//	Flags — trace flags, see TraceFlagSampled.
+type TraceParent struct {
+	TraceID string
+	SpanID  string
+	Flags   byte
+}
+
+// ParseTraceParent parses the value of a "traceparent" header.
+// Headers of future versions are accepted as long as their first fields have the version 00 format.
+func ParseTraceParent(s string) (p TraceParent, ok bool) {
+	s = strings.TrimSpace(s)
+
+	// version "-" trace-id "-" parent-id "-" trace-flags
+	if len(s) < 55 || s[2] != '-' || s[35] != '-' || s[52] != '-' {
+		return p, false
+	}
+
+	version := s[:2]
+	if !isHex(version) || version == "ff" || (version == "00" && len(s) != 55) || (len(s) > 55 && s[55] != '-') {
+		return p, false
+	}
+
+	p.TraceID, p.SpanID = s[3:35], s[36:52]
+	if !IsTraceID(p.TraceID) || !IsSpanID(p.SpanID) || !isHex(s[53:55]) {
+		return TraceParent{}, false
+	}
+
+	flags, _ := hex.DecodeString(s[53:55])
+	p.Flags = flags[0]
+
+	return p, true
+}
+
+// String formats the TraceParent as a version 00 "traceparent" header value.
+func (p TraceParent) String() string {
+	return "00-" + p.TraceID + "-" + p.SpanID + "-" + hex.EncodeToString([]byte{p.Flags})
+}
+
+// Sampled reports whether the caller may have recorded the trace.
+func (p TraceParent) Sampled() bool {
+	return p.Flags&TraceFlagSampled != 0
+}
+
+// NewTraceID returns a random W3C trace ID.
+func NewTraceID() string {
+	return randomHex(16)
+}
+
+// NewSpanID returns a random W3C span (parent) ID.
+func NewSpanID() string {
+	return randomHex(8)
+}
+
+// IsTraceID reports whether s is a valid W3C trace ID: 32 lowercase hex characters, not all zeros.
+func IsTraceID(s string) bool {
+	return len(s) == 32 && isHex(s) && strings.Trim(s, "0") != ""
+}
+
+// IsSpanID reports whether s is a valid W3C span ID: 16 lowercase hex characters, not all zeros.
+func IsSpanID(s string) bool {
+	return len(s) == 16 && isHex(s) && strings.Trim(s, "0") != ""
+}
+
+func isHex(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if c := s[i]; (c < '0' || c > '9') && (c < 'a' || c > 'f') {
+			return false
+		}
+	}
+	return true
+}
+
+func randomHex(n int) string {
+	b := make([]byte, n)
+	for {
+		_, _ = rand.Read(b)
+		// An all-zero ID is invalid.
+		for _, c := range b {
+			if c != 0 {
+				return hex.EncodeToString(b)
+			}
+		}
+	}
+}
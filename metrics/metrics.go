@@ -0,0 +1,375 @@
+package metrics
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// ContentType is the content type of the Prometheus text exposition format.
+const ContentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefBuckets are the default histogram buckets for durations in seconds.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+// SizeBuckets are histogram buckets for sizes in bytes, from 100 B to 100 MB.
+var SizeBuckets = []float64{100, 1_000, 10_000, 100_000, 1_000_000, 10_000_000, 100_000_000}
+
+var (
+	nameRe  = regexp.MustCompile(`^[a-zA-Z_:][a-zA-Z0-9_:]*$`)
+	labelRe = regexp.MustCompile(`^[a-zA-Z_][a-zA-Z0-9_]*$`)
+)
+
+type kind string
+
+const (
+	kindCounter   kind = "counter"
+	kindGauge     kind = "gauge"
+	kindHistogram kind = "histogram"
+)
+
+// Registry holds metrics and writes them in the Prometheus text exposition format.
+type Registry struct {
+	mu      sync.Mutex
+	metrics []*metric
+	byName  map[string]*metric
+}
+
+// NewRegistry returns an empty Registry.
+func NewRegistry() *Registry {
+	return &Registry{byName: make(map[string]*metric)}
+}
+
+// DefaultRegistry is used by the package-level functions and the HTTP middlewares by default.
+var DefaultRegistry = NewRegistry()
+
+// Handler returns an http.Handler that serves the metrics of DefaultRegistry, e.g. on "/metrics".
+func Handler() http.Handler {
+	return DefaultRegistry.Handler()
+}
+
+// metric is a family of series sharing a name, a type and the label names.
+type metric struct {
+	name    string
+	help    string
+	kind    kind
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+// series is a single time series identified by its label values.
+type series struct {
+	values []string
+	value  float64  // counter or gauge value
+	counts []uint64 // histogram bucket counts, not cumulative
+	sum    float64  // histogram sum
+	count  uint64   // histogram count
+}
+
+// register returns the metric with the name, creating it if necessary.
+// It panics if the name or labels are invalid or if a metric with the same name but a different
+// type, labels or buckets is already registered.
+func (r *Registry) register(name, help string, k kind, buckets []float64, labels []string) *metric {
+	if !nameRe.MatchString(name) {
+		panic(fmt.Sprintf("metrics: invalid metric name %q", name))
+	}
+	for _, label := range labels {
+		if !labelRe.MatchString(label) || strings.HasPrefix(label, "__") || (k == kindHistogram && label == "le") {
+			panic(fmt.Sprintf("metrics: invalid label name %q of metric %q", label, name))
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if m, ok := r.byName[name]; ok {
+		if m.kind != k || !slices.Equal(m.labels, labels) || !slices.Equal(m.buckets, buckets) {
+			panic(fmt.Sprintf("metrics: metric %q is already registered with a different definition", name))
+		}
+		return m
+	}
+
+	m := &metric{
+		name:    name,
+		help:    help,
+		kind:    k,
+		labels:  slices.Clone(labels),
+		buckets: buckets,
+		series:  make(map[string]*series),
+	}
+
+	r.metrics = append(r.metrics, m)
+	r.byName[name] = m
+
+	return m
+}
+
+// with returns the series of the label values, creating it if necessary. The caller must hold m.mu.
+func (m *metric) with(values []string) *series {
+	if len(values) != len(m.labels) {
+		panic(fmt.Sprintf("metrics: metric %q expects %d label values, got %d", m.name, len(m.labels), len(values)))
+	}
+
+	key := strings.Join(values, "\xff")
+
+	s, ok := m.series[key]
+	if !ok {
+		s = &series{values: slices.Clone(values)}
+		if m.kind == kindHistogram {
+			s.counts = make([]uint64, len(m.buckets))
+		}
+		m.series[key] = s
+	}
+	return s
+}
+
+// Counter is a monotonically increasing value partitioned by labels.
+type Counter struct {
+	m *metric
+}
+
+// NewCounter registers a counter in the Registry, or returns the one already registered with the same definition.
+func (r *Registry) NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{m: r.register(name, help, kindCounter, nil, labels)}
+}
+
+// Inc increments the counter of the label values by one.
+func (c *Counter) Inc(labelValues ...string) {
+	c.Add(1, labelValues...)
+}
+
+// Add adds v to the counter of the label values. It panics if v is negative.
+func (c *Counter) Add(v float64, labelValues ...string) {
+	if v < 0 {
+		panic(fmt.Sprintf("metrics: counter %q cannot decrease", c.m.name))
+	}
+	c.m.mu.Lock()
+	c.m.with(labelValues).value += v
+	c.m.mu.Unlock()
+}
+
+// Gauge is a value that can go up and down, partitioned by labels.
+type Gauge struct {
+	m *metric
+}
+
+// NewGauge registers a gauge in the Registry, or returns the one already registered with the same definition.
+func (r *Registry) NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{m: r.register(name, help, kindGauge, nil, labels)}
+}
+
+// Set sets the gauge of the label values to v.
+func (g *Gauge) Set(v float64, labelValues ...string) {
+	g.m.mu.Lock()
+	g.m.with(labelValues).value = v
+	g.m.mu.Unlock()
+}
+
+// Add adds v, which may be negative, to the gauge of the label values.
+func (g *Gauge) Add(v float64, labelValues ...string) {
+	g.m.mu.Lock()
+	g.m.with(labelValues).value += v
+	g.m.mu.Unlock()
+}
+
+// Inc increments the gauge of the label values by one.
+func (g *Gauge) Inc(labelValues ...string) {
+	g.Add(1, labelValues...)
+}
+
+// Dec decrements the gauge of the label values by one.
+func (g *Gauge) Dec(labelValues ...string) {
+	g.Add(-1, labelValues...)
+}
+
+// Histogram counts observations in buckets, partitioned by labels.
+type Histogram struct {
+	m *metric
+}
+
+// NewHistogram registers a histogram in the Registry, or returns the one already registered with the same definition.
+// The buckets are upper bounds in increasing order; DefBuckets are used if none are given.
+// The +Inf bucket is added implicitly.
+func (r *Registry) NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	if len(buckets) == 0 {
+		buckets = DefBuckets
+	}
+	if !slices.IsSorted(buckets) {
+		panic(fmt.Sprintf("metrics: buckets of histogram %q are not sorted", name))
+	}
+	if math.IsInf(buckets[len(buckets)-1], 1) {
+		buckets = buckets[:len(buckets)-1]
+	}
+	return &Histogram{m: r.register(name, help, kindHistogram, buckets, labels)}
+}
+
+// Observe adds an observation to the histogram of the label values.
+func (h *Histogram) Observe(v float64, labelValues ...string) {
+	i, _ := slices.BinarySearch(h.m.buckets, v)
+
+	h.m.mu.Lock()
+	s := h.m.with(labelValues)
+	if i < len(s.counts) {
+		s.counts[i]++
+	}
+	s.sum += v
+	s.count++
+	h.m.mu.Unlock()
+}
+
+// Handler returns an http.Handler that serves the metrics of the Registry.
+func (r *Registry) Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		w.Header().Set("Content-Type", ContentType)
+		_, _ = r.WriteTo(w)
+	})
+}
+
+// WriteTo writes all metrics to w in the Prometheus text exposition format.
+// Metrics are written in the order of registration and series are sorted by label values.
+func (r *Registry) WriteTo(w io.Writer) (int64, error) {
+	r.mu.Lock()
+	metrics := slices.Clone(r.metrics)
+	r.mu.Unlock()
+
+	cw := &countingWriter{w: bufio.NewWriter(w)}
+
+	for _, m := range metrics {
+		m.write(cw)
+	}
+
+	if cw.err == nil {
+		cw.err = cw.w.Flush()
+	}
+	return cw.n, cw.err
+}
+
+func (m *metric) write(w *countingWriter) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.series) == 0 {
+		return
+	}
+
+	if m.help != "" {
+		w.printf("# HELP %s %s\n", m.name, escapeHelp(m.help))
+	}
+	w.printf("# TYPE %s %s\n", m.name, m.kind)
+
+	keys := make([]string, 0, len(m.series))
+	for key := range m.series {
+		keys = append(keys, key)
+	}
+	slices.Sort(keys)
+
+	for _, key := range keys {
+		s := m.series[key]
+
+		if m.kind != kindHistogram {
+			w.printf("%s%s %s\n", m.name, m.labelPairs(s.values, ""), formatFloat(s.value))
+			continue
+		}
+
+		var cumulative uint64
+		for i, bound := range m.buckets {
+			cumulative += s.counts[i]
+			w.printf("%s_bucket%s %d\n", m.name, m.labelPairs(s.values, formatFloat(bound)), cumulative)
+		}
+		w.printf("%s_bucket%s %d\n", m.name, m.labelPairs(s.values, "+Inf"), s.count)
+		w.printf("%s_sum%s %s\n", m.name, m.labelPairs(s.values, ""), formatFloat(s.sum))
+		w.printf("%s_count%s %d\n", m.name, m.labelPairs(s.values, ""), s.count)
+	}
+}
+
+// labelPairs formats the labels as {name="value",...}, adding the "le" label if it is non-empty.
+func (m *metric) labelPairs(values []string, le string) string {
+	if len(values) == 0 && le == "" {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteByte('{')
+	for i, label := range m.labels {
+		if i > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(label)
+		b.WriteString(`="`)
+		b.WriteString(escapeLabel(values[i]))
+		b.WriteByte('"')
+	}
+	if le != "" {
+		if len(m.labels) > 0 {
+			b.WriteByte(',')
+		}
+		b.WriteString(`le="`)
+		b.WriteString(le)
+		b.WriteByte('"')
+	}
+	b.WriteByte('}')
+	return b.String()
+}
+
+var (
+	helpReplacer  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	labelReplacer = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpReplacer.Replace(s)
+}
+
+func escapeLabel(s string) string {
+	return labelReplacer.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	default:
+		return strconv.FormatFloat(v, 'g', -1, 64)
+	}
+}
+
+type countingWriter struct {
+	w   *bufio.Writer
+	n   int64
+	err error
+}
+
+func (w *countingWriter) printf(format string, args ...any) {
+	if w.err != nil {
+		return
+	}
+	var n int
+	n, w.err = fmt.Fprintf(w.w, format, args...)
+	w.n += int64(n)
+}
+
+// Method returns the HTTP method as a label value: one of the standard methods, or "_OTHER",
+// so that arbitrary methods sent by clients do not create new series.
+func Method(method string) string {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPost, http.MethodPut, http.MethodPatch,
+		http.MethodDelete, http.MethodConnect, http.MethodOptions, http.MethodTrace:
+		return method
+	default:
+		return "_OTHER"
+	}
+}
@@ -0,0 +1,83 @@
+package metrics_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/easysy/proton/metrics"
+)
+
+func equal(t *testing.T, exp, got any) {
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("Not equal:\nexp: %v\ngot: %v", exp, got)
+	}
+}
+
+func TestRegistry_WriteTo(t *testing.T) {
+	reg := metrics.NewRegistry()
+
+	requests := reg.NewCounter("requests_total", "Total requests.", "method", "path")
+	inFlight := reg.NewGauge("in_flight", "", "method")
+	duration := reg.NewHistogram("duration_seconds", "Request\nduration.", []float64{0.1, 1}, "method")
+	reg.NewCounter("unused_total", "Never incremented.")
+
+	requests.Inc("GET", `/a"b`)
+	requests.Add(2, "GET", "/")
+	inFlight.Inc("POST")
+	inFlight.Dec("POST")
+	inFlight.Inc("GET")
+	duration.Observe(0.05, "GET")
+	duration.Observe(0.1, "GET")
+	duration.Observe(5, "GET")
+
+	// registering the same definition again returns the existing metric
+	reg.NewCounter("requests_total", "Total requests.", "method", "path").Inc("GET", "/")
+
+	exp := `# HELP requests_total Total requests.
+# TYPE requests_total counter
+requests_total{method="GET",path="/"} 3
+requests_total{method="GET",path="/a\"b"} 1
+# TYPE in_flight gauge
+in_flight{method="GET"} 1
+in_flight{method="POST"} 0
+# HELP duration_seconds Request\nduration.
+# TYPE duration_seconds histogram
+duration_seconds_bucket{method="GET",le="0.1"} 2
+duration_seconds_bucket{method="GET",le="1"} 2
+duration_seconds_bucket{method="GET",le="+Inf"} 3
+duration_seconds_sum{method="GET"} 5.15
+duration_seconds_count{method="GET"} 3
+`
+
+	w := httptest.NewRecorder()
+	reg.Handler().ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/metrics", nil))
+
+	equal(t, metrics.ContentType, w.Header().Get("Content-Type"))
+	equal(t, exp, w.Body.String())
+}
+
+func TestRegistry_Conflict(t *testing.T) {
+	reg := metrics.NewRegistry()
+	reg.NewCounter("requests_total", "", "method")
+
+	var tests = []struct {
+		name string
+		f    func()
+	}{
+		{name: "different type", f: func() { reg.NewGauge("requests_total", "", "method") }},
+		{name: "different labels", f: func() { reg.NewCounter("requests_total", "", "status") }},
+		{name: "invalid name", f: func() { reg.NewCounter("requests-total", "") }},
+		{name: "wrong label count", f: func() { reg.NewCounter("requests_total", "", "method").Inc() }},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			defer func() {
+				equal(t, true, recover() != nil)
+			}()
+			test.f()
+		})
+	}
+}
@@ -0,0 +1,431 @@
+package validate
+
+import (
+	"errors"
+	"fmt"
+	"net/mail"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Tag is the struct tag holding the validation rules of a field.
+const Tag = "validate"
+
+// FieldError describes a field that failed a validation rule.
+type FieldError struct {
+	// Field is the path of the field, made of the JSON names (or Go names) of the fields
+	// and the slice indexes, e.g. "items[0].name".
+	Field string `json:"field" xml:"field"`
+
+	// Rule is the name of the failed rule, e.g. "required" or "max".
+	Rule string `json:"rule" xml:"rule"`
+
+	// Param is the parameter of the rule, e.g. "10" for "max=10".
+	Param string `json:"param,omitempty" xml:"param,omitempty"`
+
+	// Message is a human-readable description of the failure.
+	Message string `json:"message" xml:"message"`
+}
+
+func (e *FieldError) Error() string {
+	if e.Field == "" {
+		return e.Message
+	}
+	return e.Field + ": " + e.Message
+}
+
+// Errors is the list of all fields that failed validation.
+type Errors []*FieldError
+
+func (e Errors) Error() string {
+	s := make([]string, len(e))
+	for i, fe := range e {
+		s[i] = fe.Error()
+	}
+	return strings.Join(s, "; ")
+}
+
+// A Validator validates itself. It is called by Struct after the tag rules of its fields have passed,
+// e.g. to check constraints between fields. An Errors value returned by Validate is merged
+// with the paths of its fields prefixed; any other error is reported as a failed "custom" rule.
+type Validator interface {
+	Validate() error
+}
+
+// Struct validates v, a struct or a pointer to a struct, by the rules in the `validate` tags of its fields,
+// and returns Errors listing every failed field, or nil. Nested structs, pointers to structs and
+// the elements of slices, arrays and maps of structs are validated recursively. The supported rules are:
+//
+//	required      the value is not zero: non-empty string, slice or map, non-nil pointer
+//	omitempty     the other rules are skipped for a zero value
+//	min=N, max=N  bounds of a number, or of the length of a string (in characters), slice or map
+//	len=N         exact length of a string (in characters), slice or map
+//	oneof=a b c   the value, formatted as a string, is one of the space-separated values
+//	email         a plain e-mail address, e.g. "gopher@example.com"
+//	url           an absolute URL with a scheme and a host
+//	pattern=RE    the string matches the regular expression; it must be the last rule,
+//	              as its value extends to the end of the tag, commas included
+//
+// Rules of a nil pointer are skipped, except required. Rules are separated by commas, e.g.
+// `validate:"required,min=1,max=100"`. Struct panics if a tag has an unknown rule or an invalid parameter.
+func Struct(v any) error {
+	var errs Errors
+	validateValue(reflect.ValueOf(v), "", &errs)
+	if len(errs) == 0 {
+		return nil
+	}
+	return errs
+}
+
+// Compile parses the rules of the struct type t, or of the struct t points to, and of the structs
+// it contains, so that an invalid tag panics at once rather than on the first call of Struct.
+func Compile(t reflect.Type) {
+	compile(t, make(map[reflect.Type]bool))
+}
+
+func compile(t reflect.Type, seen map[reflect.Type]bool) {
+	for t.Kind() == reflect.Pointer || t.Kind() == reflect.Slice || t.Kind() == reflect.Array || t.Kind() == reflect.Map {
+		t = t.Elem()
+	}
+
+	if t.Kind() != reflect.Struct || seen[t] {
+		return
+	}
+	seen[t] = true
+
+	for _, f := range planFor(t) {
+		compile(t.FieldByIndex(f.index).Type, seen)
+	}
+}
+
+var validatorType = reflect.TypeFor[Validator]()
+
+func validateValue(v reflect.Value, path string, errs *Errors) {
+	for v.Kind() == reflect.Pointer || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+
+	switch v.Kind() {
+	case reflect.Struct:
+		validateStruct(v, path, errs)
+	case reflect.Slice, reflect.Array:
+		if !diveable(v.Type().Elem()) {
+			return
+		}
+		for i := range v.Len() {
+			validateValue(v.Index(i), path+"["+strconv.Itoa(i)+"]", errs)
+		}
+	case reflect.Map:
+		if !diveable(v.Type().Elem()) {
+			return
+		}
+		iter := v.MapRange()
+		for iter.Next() {
+			validateValue(iter.Value(), path+"["+fmt.Sprint(iter.Key().Interface())+"]", errs)
+		}
+	default:
+	}
+}
+
+// diveable reports whether the values of the type may contain structs to validate.
+func diveable(t reflect.Type) bool {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	switch t.Kind() {
+	case reflect.Struct, reflect.Interface:
+		return true
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return diveable(t.Elem())
+	default:
+		return false
+	}
+}
+
+func validateStruct(v reflect.Value, path string, errs *Errors) {
+	plan := planFor(v.Type())
+
+	failed := len(*errs)
+
+	for _, f := range plan {
+		field := v.FieldByIndex(f.index)
+		fieldPath := joinPath(path, f.name)
+
+		f.check(field, fieldPath, errs)
+		validateValue(field, fieldPath, errs)
+	}
+
+	if len(*errs) > failed {
+		return
+	}
+
+	var validator Validator
+	if v.CanAddr() && v.Addr().Type().Implements(validatorType) {
+		validator = v.Addr().Interface().(Validator)
+	} else if v.Type().Implements(validatorType) {
+		validator = v.Interface().(Validator)
+	}
+	if validator == nil {
+		return
+	}
+
+	err := validator.Validate()
+	if err == nil {
+		return
+	}
+
+	var inner Errors
+	if errors.As(err, &inner) {
+		for _, fe := range inner {
+			*errs = append(*errs, &FieldError{Field: joinPath(path, fe.Field), Rule: fe.Rule, Param: fe.Param, Message: fe.Message})
+		}
+		return
+	}
+
+	*errs = append(*errs, &FieldError{Field: path, Rule: "custom", Message: err.Error()})
+}
+
+func joinPath(path, name string) string {
+	switch {
+	case path == "":
+		return name
+	case name == "":
+		return path
+	case strings.HasPrefix(name, "["):
+		return path + name
+	default:
+		return path + "." + name
+	}
+}
+
+// fieldPlan holds the parsed rules of a struct field.
+type fieldPlan struct {
+	index     []int
+	name      string
+	required  bool
+	omitempty bool
+	rules     []rule
+}
+
+type rule struct {
+	name  string
+	param string
+	check func(v reflect.Value) (string, bool)
+}
+
+var plans sync.Map // reflect.Type -> []*fieldPlan
+
+func planFor(t reflect.Type) []*fieldPlan {
+	if plan, ok := plans.Load(t); ok {
+		return plan.([]*fieldPlan)
+	}
+	plan, _ := plans.LoadOrStore(t, buildPlan(t, nil))
+	return plan.([]*fieldPlan)
+}
+
+func buildPlan(t reflect.Type, index []int) []*fieldPlan {
+	var plan []*fieldPlan
+
+	for i := range t.NumField() {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		fieldIndex := append(append([]int(nil), index...), i)
+
+		tag := field.Tag.Get(Tag)
+		if tag == "-" {
+			continue
+		}
+
+		if field.Anonymous && field.Type.Kind() == reflect.Struct && tag == "" {
+			plan = append(plan, buildPlan(field.Type, fieldIndex)...)
+			continue
+		}
+
+		f := &fieldPlan{index: fieldIndex, name: fieldName(field)}
+		parseRules(f, field, tag)
+		plan = append(plan, f)
+	}
+
+	return plan
+}
+
+// fieldName returns the JSON name of the field, if any, or its Go name.
+func fieldName(field reflect.StructField) string {
+	name, _, _ := strings.Cut(field.Tag.Get("json"), ",")
+	if name == "" || name == "-" {
+		return field.Name
+	}
+	return name
+}
+
+func parseRules(f *fieldPlan, field reflect.StructField, tag string) {
+	for tag != "" {
+		var item string
+		if strings.HasPrefix(tag, "pattern=") {
+			item, tag = tag, ""
+		} else {
+			item, tag, _ = strings.Cut(tag, ",")
+		}
+
+		name, param, _ := strings.Cut(strings.TrimSpace(item), "=")
+
+		switch name {
+		case "":
+			continue
+		case "required":
+			f.required = true
+			continue
+		case "omitempty":
+			f.omitempty = true
+			continue
+		}
+
+		check, err := newCheck(name, param, field.Type)
+		if err != nil {
+			panic(fmt.Sprintf("validate: field %s: rule %q: %v", field.Name, item, err))
+		}
+		f.rules = append(f.rules, rule{name: name, param: param, check: check})
+	}
+}
+
+func (f *fieldPlan) check(v reflect.Value, path string, errs *Errors) {
+	if v.IsZero() {
+		if f.required {
+			*errs = append(*errs, &FieldError{Field: path, Rule: "required", Message: "is required"})
+			return
+		}
+		if f.omitempty {
+			return
+		}
+	}
+
+	for v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return
+		}
+		v = v.Elem()
+	}
+
+	for _, r := range f.rules {
+		if msg, ok := r.check(v); !ok {
+			*errs = append(*errs, &FieldError{Field: path, Rule: r.name, Param: r.param, Message: msg})
+		}
+	}
+}
+
+func newCheck(name, param string, t reflect.Type) (func(v reflect.Value) (string, bool), error) {
+	for t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+
+	switch name {
+	case "min", "max":
+		n, err := strconv.ParseFloat(param, 64)
+		if err != nil {
+			return nil, err
+		}
+		measure, unit, err := measurer(t)
+		if err != nil {
+			return nil, err
+		}
+		if name == "min" {
+			return func(v reflect.Value) (string, bool) {
+				return fmt.Sprintf("must be at least %s%s", param, unit), measure(v) >= n
+			}, nil
+		}
+		return func(v reflect.Value) (string, bool) {
+			return fmt.Sprintf("must be at most %s%s", param, unit), measure(v) <= n
+		}, nil
+
+	case "len":
+		n, err := strconv.Atoi(param)
+		if err != nil {
+			return nil, err
+		}
+		measure, unit, err := measurer(t)
+		if err != nil || unit == "" {
+			return nil, errors.New("requires a string, slice or map")
+		}
+		return func(v reflect.Value) (string, bool) {
+			return fmt.Sprintf("must have exactly %d%s", n, unit), measure(v) == float64(n)
+		}, nil
+
+	case "oneof":
+		allowed := strings.Fields(param)
+		if len(allowed) == 0 {
+			return nil, errors.New("no values")
+		}
+		return func(v reflect.Value) (string, bool) {
+			s := fmt.Sprint(v.Interface())
+			for _, a := range allowed {
+				if s == a {
+					return "", true
+				}
+			}
+			return "must be one of " + strings.Join(allowed, ", "), false
+		}, nil
+
+	case "pattern":
+		if t.Kind() != reflect.String {
+			return nil, errors.New("requires a string")
+		}
+		re, err := regexp.Compile(param)
+		if err != nil {
+			return nil, err
+		}
+		return func(v reflect.Value) (string, bool) {
+			return "must match " + param, re.MatchString(v.String())
+		}, nil
+
+	case "email":
+		if t.Kind() != reflect.String {
+			return nil, errors.New("requires a string")
+		}
+		return func(v reflect.Value) (string, bool) {
+			addr, err := mail.ParseAddress(v.String())
+			return "must be a valid e-mail address", err == nil && addr.Name == "" && addr.Address == v.String()
+		}, nil
+
+	case "url":
+		if t.Kind() != reflect.String {
+			return nil, errors.New("requires a string")
+		}
+		return func(v reflect.Value) (string, bool) {
+			u, err := url.Parse(v.String())
+			return "must be a valid URL", err == nil && u.Scheme != "" && u.Host != ""
+		}, nil
+
+	default:
+		return nil, errors.New("unknown rule")
+	}
+}
+
+// measurer returns a function measuring values of the type for the min, max and len rules,
+// and the unit appended to the messages.
+func measurer(t reflect.Type) (func(v reflect.Value) float64, string, error) {
+	switch t.Kind() {
+	case reflect.String:
+		return func(v reflect.Value) float64 { return float64(utf8.RuneCountInString(v.String())) }, " characters", nil
+	case reflect.Slice, reflect.Array, reflect.Map:
+		return func(v reflect.Value) float64 { return float64(v.Len()) }, " items", nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return func(v reflect.Value) float64 { return float64(v.Int()) }, "", nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return func(v reflect.Value) float64 { return float64(v.Uint()) }, "", nil
+	case reflect.Float32, reflect.Float64:
+		return func(v reflect.Value) float64 { return v.Float() }, "", nil
+	default:
+		return nil, "", fmt.Errorf("unsupported type %s", t)
+	}
+}
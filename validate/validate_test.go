@@ -0,0 +1,130 @@
+package validate_test
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+
+	"github.com/easysy/proton/validate"
+)
+
+func equal(t *testing.T, exp, got any) {
+	if !reflect.DeepEqual(exp, got) {
+		t.Fatalf("Not equal:\nexp: %v\ngot: %v", exp, got)
+	}
+}
+
+type item struct {
+	SKU      string `json:"sku" validate:"required,pattern=^[A-Z]{3}-\\d{1,3}$"`
+	Quantity int    `json:"quantity" validate:"min=1,max=10"`
+}
+
+type order struct {
+	Email    string            `json:"email" validate:"required,email"`
+	Callback string            `json:"callback,omitempty" validate:"omitempty,url"`
+	Status   string            `json:"status" validate:"oneof=new paid"`
+	Note     *string           `json:"note" validate:"max=5"`
+	Code     string            `json:"code" validate:"len=4"`
+	Items    []item            `json:"items" validate:"required,max=2"`
+	Labels   map[string]*item  `json:"labels"`
+	Internal string            `json:"-" validate:"-"`
+	Extra    map[string]string `json:"extra"`
+}
+
+type period struct {
+	From int `json:"from"`
+	To   int `json:"to"`
+}
+
+func (p *period) Validate() error {
+	if p.From > p.To {
+		return validate.Errors{{Field: "to", Rule: "gtefield", Param: "from", Message: "must not be before from"}}
+	}
+	return nil
+}
+
+func TestStruct(t *testing.T) {
+	note := "too long"
+
+	var tests = []struct {
+		name   string
+		input  any
+		fields []string
+	}{
+		{
+			name: "valid",
+			input: &order{
+				Email:  "gopher@example.com",
+				Status: "new",
+				Code:   "ABCD",
+				Items:  []item{{SKU: "ABC-1", Quantity: 1}},
+				Labels: map[string]*item{"gift": nil},
+			},
+		},
+		{
+			name: "invalid",
+			input: order{
+				Email:    "Gopher <gopher@example.com>",
+				Callback: "/relative",
+				Status:   "lost",
+				Note:     &note,
+				Code:     "ABC",
+				Items:    []item{{SKU: "abc", Quantity: 1}, {SKU: "ABC-1"}, {SKU: "ABC-2", Quantity: 11}},
+				Labels:   map[string]*item{"gift": {SKU: "ABC-3"}},
+			},
+			fields: []string{
+				"email", "callback", "status", "note", "code", "items",
+				"items[0].sku", "items[1].quantity", "items[2].quantity", "labels[gift].quantity",
+			},
+		},
+		{
+			name:   "required",
+			input:  &order{Status: "paid", Code: "ABCD"},
+			fields: []string{"email", "items"},
+		},
+		{
+			name:   "custom validator",
+			input:  &struct{ Period period }{Period: period{From: 2, To: 1}},
+			fields: []string{"Period.to"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			err := validate.Struct(test.input)
+			if test.fields == nil {
+				equal(t, nil, err)
+				return
+			}
+
+			var errs validate.Errors
+			equal(t, true, errors.As(err, &errs))
+
+			fields := make([]string, len(errs))
+			for i, fe := range errs {
+				fields[i] = fe.Field
+			}
+			equal(t, test.fields, fields)
+		})
+	}
+}
+
+func TestStruct_InvalidTag(t *testing.T) {
+	defer func() {
+		equal(t, true, recover() != nil)
+	}()
+	_ = validate.Struct(struct {
+		N int `validate:"pattern=a"`
+	}{})
+}
+
+func TestCompile_InvalidTag(t *testing.T) {
+	type inner struct {
+		N int `validate:"email"`
+	}
+
+	defer func() {
+		equal(t, true, recover() != nil)
+	}()
+	validate.Compile(reflect.TypeFor[*struct{ Items []inner }]())
+}